@@ -2,36 +2,63 @@ package app
 
 import (
 	"errors"
-	"golang.org/x/sys/unix"
-	"os"
+	"fmt"
 	"pirecorder/app/audio"
+	"pirecorder/app/catalog"
 	"pirecorder/app/helper"
+	"pirecorder/app/retention"
 	"pirecorder/app/upload"
 	"pirecorder/app/video"
 	"pirecorder/apperror"
 	"pirecorder/config"
 	"pirecorder/logger"
 	"pirecorder/models"
+	"time"
 )
 
+const (
+	modeAudioVideo = "audio-video"
+	modeAudioOnly  = "audio-only"
+)
+
+// errUploaderDown is returned by upload requests when the uploader couldn't be set up.
+var errUploaderDown = apperror.ServiceUnavailable.SetMessage("Uploads are not available, check the upload configuration")
+
 type App struct {
-	camera   *video.Camera
-	mic      *audio.Mic
-	uploader *upload.Uploader
-	logger   *logger.Logger
+	camera         *video.Camera
+	mic            *audio.Mic
+	uploader       *upload.Uploader
+	logger         *logger.Logger
+	audioOnly      bool
+	recordingAudio bool // audio-only mode of the recording in progress
+	recordingName  string
+	recordingStart time.Time
+	recordingMeta  map[string]string // metadata sent with the request that started the recording
+	recordingID    string            // the recording in progress, empty when there is none
+	catalog        *catalog.Catalog
+	retention      *retention.Manager
 }
 
 func NewApp(logger *logger.Logger) (*App, error) {
 	var (
 		videoErr  bool
+		audioErr  bool
 		uploadErr bool
+		cam       *video.Camera
+		err       error
 	)
-	logger.LogInfo("Initializing camera")
-	cam, err := video.NewCamera(logger)
+	audioOnly := config.GetConfig().AudioOnly
 
-	if err != nil {
-		logger.LogError(err, "Error initializing camera")
-		videoErr = true
+	if audioOnly {
+		logger.LogInfo("Running in audio-only mode, camera will not be started")
+	} else {
+		logger.LogInfo("Initializing camera")
+		cam, err = video.NewCamera(logger)
+
+		if err != nil {
+			logger.LogError(err, "Error initializing camera")
+			videoErr = true
+		}
 	}
 
 	logger.LogInfo("Initializing microphone")
@@ -39,6 +66,7 @@ func NewApp(logger *logger.Logger) (*App, error) {
 
 	if err != nil {
 		logger.LogError(err, "Error initializing microphone")
+		audioErr = true
 	}
 
 	logger.LogInfo("Initializing uploader")
@@ -55,17 +83,98 @@ func NewApp(logger *logger.Logger) (*App, error) {
 		return nil, err
 	}
 
-	uploader.UploadLogs()
+	if audioOnly && audioErr && uploadErr {
+		err := errors.New("error initializing microphone and uploader")
+		logger.LogError(err, "Error initializing microphone and uploader")
+		return nil, err
+	}
+
+	if !uploadErr {
+		go uploader.RunLogUploads()
+	}
+
+	recordings, err := catalog.NewCatalog(fmt.Sprintf("%s/catalog.db", config.GetConfig().DataFolder))
+
+	if err != nil {
+		logger.LogError(err, "Error loading recordings catalog")
+		return nil, err
+	}
+
+	a := &App{
+		camera:    cam,
+		mic:       mic,
+		logger:    logger,
+		uploader:  uploader,
+		audioOnly: audioOnly,
+		catalog:   recordings,
+	}
+
+	if !uploadErr {
+		uploader.OnUploaded(a.fileUploaded)
+		uploader.CheckWriting(a.isWriting)
+	}
+
+	if err = a.reconcile(); err != nil {
+		logger.LogError(err, "Error reconciling recordings catalog")
+	}
+
+	go a.pruneCatalog()
+
+	mic.OnClipFinished(a.clipFinished)
+
+	if config.GetConfig().AudioConfig.VADEnabled {
+		if err := mic.StartListening(); err != nil {
+			logger.LogError(err, "Error starting voice activated recording")
+		}
+	}
+
+	uploadedAt := func(string) (time.Time, bool) { return time.Time{}, false }
+	if !uploadErr {
+		uploadedAt = uploader.UploadedAt
+	}
+
+	a.retention = retention.NewManager(logger, config.GetConfig().Retention, a.isBusy, uploadedAt)
+	a.retention.OnDelete(a.fileDeleted)
+	go a.retention.Run()
+
+	return a, nil
+}
+
+// isBusy reports whether a recording is being written or uploaded.
+func (a *App) isBusy(filename string) bool {
+	if a.isWriting(filename) {
+		return true
+	}
+
+	if a.uploader == nil {
+		return false
+	}
+
+	for _, progress := range a.uploader.Progress() {
+		if progress.Filename == filename {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *App) camStatus() bool {
+	return a.camera != nil && a.camera.CamStatus()
+}
 
-	return &App{
-		camera:   cam,
-		mic:      mic,
-		logger:   logger,
-		uploader: uploader,
-	}, nil
+func (a *App) camRecordingStats() (bool, string) {
+	if a.camera == nil {
+		return false, ""
+	}
+	return a.camera.RecordingStats()
 }
 
 func (a *App) StartStream() (chan []byte, chan struct{}, error) {
+	if a.camera == nil {
+		return nil, nil, apperror.ServiceUnavailable.SetMessage("Camera is disabled in audio-only mode")
+	}
+
 	stream, closeChan, err := a.camera.StartStream()
 
 	if err != nil {
@@ -76,21 +185,158 @@ func (a *App) StartStream() (chan []byte, chan struct{}, error) {
 	return stream, closeChan, err
 }
 
+func (a *App) StartLevelStream() (chan models.AudioLevels, chan struct{}, error) {
+	levels, closeChan, err := a.mic.StartLevelStream()
+
+	if err != nil {
+		a.logger.LogError(err, "Error starting audio level stream")
+		err = apperror.ServiceUnavailable.SetMessage(err.Error())
+	}
+
+	return levels, closeChan, err
+}
+
+func (a *App) StartAudioStream(format string) (chan []byte, chan struct{}, string, error) {
+	contentType, err := audio.StreamContentType(format)
+
+	if err != nil {
+		return nil, nil, "", apperror.InvalidRequest.SetMessage(err.Error())
+	}
+
+	stream, closeChan, err := a.mic.StartAudioStream(format)
+
+	if err != nil {
+		a.logger.LogError(err, "Error starting audio stream", "format", format)
+		return nil, nil, "", apperror.ServiceUnavailable.SetMessage(err.Error())
+	}
+
+	return stream, closeChan, contentType, nil
+}
+
+func (a *App) ListAudioDevices() ([]models.AudioDevice, error) {
+	devices, err := a.mic.ListSources()
+
+	if err != nil {
+		a.logger.LogError(err, "Error listing audio devices")
+		return nil, apperror.ServiceUnavailable.SetMessage(err.Error())
+	}
+
+	return devices, nil
+}
+
+func (a *App) SelectAudioDevice(name string) error {
+	err := a.mic.SetSource(name)
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, audio.ErrSourceNotFound):
+		a.logger.LogError(err, "Audio device not found", "source", name)
+		return apperror.NotFound.SetMessage("Audio device not found")
+	case errors.Is(err, audio.ErrMicUnavailable):
+		a.logger.LogError(err, "Cannot select audio device, microphone is not available", "source", name)
+		return apperror.ServiceUnavailable.SetMessage("Microphone is not available")
+	default:
+		a.logger.LogError(err, "Error selecting audio device", "source", name)
+		return apperror.ServerError
+	}
+}
+
 func (a *App) StopStream() {
 	a.logger.LogInfo("Stopping the stream")
 }
 
-func (a *App) StartRecording(filename string) error {
+// StartRecording starts a new recording session from both the camera and the mic,
+// unless audio-only mode is configured or requested, in which case the camera is
+// never touched. The request metadata ends up in the manifest uploaded with the recording.
+// A recording in progress has to be stopped first.
+func (a *App) StartRecording(request models.StartRecordingRequest) (*models.Recording, error) {
+	if a.recordingID != "" {
+		return nil, apperror.ServiceUnavailable.SetMessage("A recording is already in progress, stop it first")
+	}
+
+	audioOnly := request.AudioOnly || a.audioOnly
+	a.retention.Trigger() // make room for the new recording
+
+	id, err := catalog.NewID()
+
+	if err != nil {
+		a.logger.LogError(err, "Error generating recording id")
+		return nil, apperror.ServerError
+	}
+
+	filename := request.Filename
+	if filename == "" {
+		filename = id
+	} else if err = helper.ValidateName(filename); err != nil {
+		a.logger.LogError(err, "Invalid recording name", "filename", filename)
+		return nil, err
+	}
+
+	// never overwrite an earlier recording, on disk or in the catalog
+	unique, err := helper.UniqueName(filename, a.nameTaken)
+
+	if err != nil {
+		a.logger.LogError(err, "Error finding an unused recording name", "filename", filename)
+
+		if errors.Is(err, apperror.InvalidRequest) {
+			return nil, err
+		}
+		return nil, apperror.ServerError
+	}
+
+	filename = unique
+	a.recordingMeta = request.Metadata
+
+	if audioOnly {
+		err = a.startAudioRecording(filename)
+	} else {
+		err = a.startAudioVideoRecording(filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	recording := models.Recording{
+		ID:        id,
+		Label:     request.Label,
+		Tags:      request.Tags,
+		Mode:      a.recordingMode(),
+		Status:    catalog.StatusRecording,
+		StartedAt: a.recordingStart.UTC(),
+		Metadata:  request.Metadata,
+		Files:     a.recordingFiles(filename),
+	}
+
+	if err = a.catalog.Add(recording); err != nil {
+		a.logger.LogError(err, "Error saving recording", "id", id)
+	}
+
+	a.recordingID = id
+	a.logger.LogInfo("Started recording", "id", id, "filename", filename)
+	return a.recordingDetails(recording, a.uploadsByFile()), nil
+}
+
+func (a *App) startAudioVideoRecording(filename string) error {
 	var (
 		camErr bool
 		micErr bool
 	)
+
+	// both recorders are measured against the same monotonic start time
+	a.recordingStart = time.Now()
+	a.recordingName = filename
+
 	if err := a.camera.StartRecording(filename); err != nil {
 		a.logger.LogError(err, "Error starting camera recording")
 		camErr = true
 	}
 
-	a.uploader.InformRecordingStart()
+	if a.uploader != nil {
+		a.uploader.InformRecordingStart()
+	}
+	a.recordingAudio = false
 
 	if err := a.mic.StartRecording(filename); err != nil {
 		a.logger.LogError(err, "Error starting mic recording")
@@ -100,91 +346,250 @@ func (a *App) StartRecording(filename string) error {
 	if camErr && micErr {
 		err := errors.New("error starting camera and mic recording")
 		a.logger.LogError(err, "Error starting camera and mic recording")
+		a.recordingName = ""
+		return apperror.ServerError.SetMessage(err.Error())
+	}
+
+	if camErr || micErr {
+		a.recordingName = "" // nothing to align
+	}
+	return nil
+}
+
+func (a *App) startAudioRecording(filename string) error {
+	a.logger.LogInfo("Starting audio-only recording", "filename", filename)
+	a.recordingStart = time.Now()
+
+	if err := a.mic.StartRecording(filename); err != nil {
+		a.logger.LogError(err, "Error starting mic recording")
+		if errors.Is(err, apperror.ServiceUnavailable) {
+			return err
+		}
 		return apperror.ServerError.SetMessage(err.Error())
 	}
+
+	if a.uploader != nil {
+		a.uploader.InformRecordingStart()
+	}
+	a.recordingAudio = true
 	return nil
 }
 
-func (a *App) StopRecording() {
-	a.camera.StopRecording()
+// StopRecording stops the current recording and returns it, nil if there was none.
+func (a *App) StopRecording() *models.Recording {
+	_, videoFile := a.camRecordingStats()
+	_, audioFile := a.mic.RecordingStats()
+
+	if a.mic.ListeningStatus() {
+		audioFile = "" // voice activated clips are queued when they close
+	}
+
+	if a.camera != nil {
+		a.camera.StopRecording()
+	}
 	a.mic.StopRecording()
-	a.uploader.InformRecordingStop()
+
+	if a.uploader != nil {
+		a.uploader.InformRecordingStop()
+	}
+
+	if a.recordingName != "" && !a.recordingAudio {
+		a.writeSyncInfo(a.recordingName, a.recordingStart)
+		a.recordingName = ""
+	}
+
+	a.saveManifests(videoFile, audioFile)
+	id := a.recordingID
+	a.finishRecording()
+
+	if a.uploader != nil && config.GetConfig().StoreConfig.AutoUpload {
+		for _, file := range []string{videoFile, audioFile} {
+			if file != "" {
+				a.uploader.Enqueue(file)
+			}
+		}
+	}
+
+	a.retention.Trigger()
+
+	if recording, ok := a.catalog.Get(id); ok {
+		return a.recordingDetails(recording, a.uploadsByFile())
+	}
+	return nil
 }
 
-func (a *App) UploadRecording(filename string) error {
-	return a.uploader.UploadRecording(filename)
+func (a *App) UploadQueue() []models.QueueItem {
+	if a.uploader == nil {
+		return []models.QueueItem{}
+	}
+	return a.uploader.QueueItems()
 }
 
-func (a *App) UploadRecordings() error {
-	return a.uploader.UploadRecordings()
+func (a *App) UploadProgress() []models.UploadProgress {
+	if a.uploader == nil {
+		return []models.UploadProgress{}
+	}
+	return a.uploader.Progress()
 }
 
-func (a *App) FetchRecordings() ([]models.FileDetails, error) {
-	videosFolder := config.GetConfig().VideosFolder
-	a.logger.LogInfo("Fetching available recordings", "folder_name", videosFolder)
+func (a *App) WebhookDeliveries() []models.WebhookDelivery {
+	if a.uploader == nil {
+		return []models.WebhookDelivery{}
+	}
+	return a.uploader.WebhookDeliveries()
+}
 
-	fd, err := os.Open(videosFolder)
+func (a *App) recordingMode() string {
+	if a.audioOnly {
+		return modeAudioOnly
+	}
 
-	if err != nil {
-		a.logger.LogError(err, "Error opening videos folder", "folder_name", videosFolder)
-		return nil, apperror.ServerError
+	if recording, _ := a.mic.RecordingStats(); recording && a.recordingAudio {
+		return modeAudioOnly
 	}
 
-	defer func() { _ = fd.Close() }()
+	return modeAudioVideo
+}
 
-	files, err := helper.FetchFiles()
+func (a *App) StartListening() error {
+	if err := a.mic.StartListening(); err != nil {
+		a.logger.LogError(err, "Error starting voice activated recording")
+		if errors.Is(err, apperror.ServiceUnavailable) {
+			return err
+		}
+		return apperror.ServiceUnavailable.SetMessage(err.Error())
+	}
+	return nil
+}
+
+func (a *App) StopListening() {
+	a.mic.StopListening()
+}
+
+// UploadRecording uploads a recording file, the returned DeferredUpload is set when it
+// was queued for the next upload window instead.
+func (a *App) UploadRecording(filename string, ignoreWindow bool) (*models.DeferredUpload, error) {
+	if err := helper.ValidateFilename(filename); err != nil {
+		a.logger.LogError(err, "Invalid recording file name", "filename", filename)
+		return nil, err
+	}
+
+	if a.uploader == nil {
+		return nil, errUploaderDown
+	}
+
+	return a.uploader.UploadRecording(filename, ignoreWindow)
+}
+
+func (a *App) UploadRecordings() (*models.DeferredUpload, error) {
+	if a.uploader == nil {
+		return nil, errUploaderDown
+	}
+	return a.uploader.UploadRecordings()
+}
+
+// FetchRecordings returns the files on disk of the recordings matching filter.
+func (a *App) FetchRecordings(filter models.RecordingFilter) ([]models.FileDetails, error) {
+	a.logger.LogInfo("Fetching available recordings")
+
+	recordings, err := a.ListRecordings(filter)
 
 	if err != nil {
-		a.logger.LogError(err, "Error reading videos folder", "folder_name", videosFolder)
-		return nil, apperror.ServerError
+		return nil, err
 	}
 
-	var (
-		fileDetails []models.FileDetails
-		ext         string
-	)
+	fileDetails := make([]models.FileDetails, 0, len(recordings))
 
-	for _, file := range files {
-		ext = file[len(file)-4:]
-		if ext != ".avi" && ext != ".wav" {
-			continue
-		}
-		fileDetail := models.FileDetails{
-			Filename: file,
-		}
+	for _, recording := range recordings {
+		for _, file := range recording.Files {
+			if file.Local == nil {
+				continue
+			}
 
-		if camRecording, filename := a.camera.RecordingStats(); camRecording && file == filename {
-			fileDetail.Recording = true
-		} else if fileUploading, filename := a.uploader.UploadStats(); fileUploading && file == filename {
-			fileDetail.Uploading = true
+			fileDetail := *file.Local
+			fileDetail.RecordingID = recording.ID
+			fileDetails = append(fileDetails, fileDetail)
 		}
-
-		fileDetails = append(fileDetails, fileDetail)
 	}
 
 	return fileDetails, nil
 }
 
+func (a *App) uploadsByFile() map[string]models.UploadProgress {
+	uploads := make(map[string]models.UploadProgress)
+	for _, progress := range a.UploadProgress() {
+		uploads[progress.Filename] = progress
+	}
+	return uploads
+}
+
+// fileDetails describes a recording file that is on disk.
+func (a *App) fileDetails(file string, uploads map[string]models.UploadProgress) models.FileDetails {
+	fileDetail := models.FileDetails{
+		Filename: file,
+	}
+
+	if camRecording, filename := a.camRecordingStats(); camRecording && file == filename {
+		fileDetail.Recording = true
+	} else if micRecording, filename := a.mic.RecordingStats(); micRecording && file == filename {
+		fileDetail.Recording = true
+	} else if progress, ok := uploads[file]; ok {
+		fileDetail.Uploading = true
+		fileDetail.Progress = &progress
+	}
+
+	if a.uploader != nil {
+		fileDetail.Upload = a.uploader.UploadState(file)
+	}
+	return fileDetail
+}
+
 func (a *App) AppStatus() *models.Status {
-	var stat unix.Statfs_t
-	recordStat, _ := a.camera.RecordingStats()
-	uploadStat, _ := a.uploader.UploadStats()
+	recordStat, _ := a.camRecordingStats()
 
-	if err := unix.Statfs("/home", &stat); err != nil {
+	if micRecording, _ := a.mic.RecordingStats(); micRecording && a.recordingMode() == modeAudioOnly {
+		recordStat = true
+	}
+	var (
+		uploadStat bool
+		throughput float64
+		windowOpen bool
+		nextWindow time.Time
+	)
+
+	if a.uploader != nil {
+		uploadStat = len(a.uploader.Progress()) > 0
+		throughput = a.uploader.Throughput()
+		windowOpen, nextWindow = a.uploader.UploadWindow()
+	}
+
+	// the same measure retention keeps free space with
+	free, err := retention.FreePercent()
+
+	if err != nil {
 		a.logger.LogError(err, "Error getting disk usage")
 		return nil
 	}
 
-	availableBlocks := float32(stat.Bavail) * float32(stat.Bsize)
-	totalBlocks := float32(stat.Blocks) * float32(stat.Bsize)
-	availPercentage := (100 - ((availableBlocks / totalBlocks) * 100)) / 100
-
-	availPercentage = float32(helper.Truncate(float64(availPercentage), 0.01))
+	availPercentage := float32(helper.Truncate((100-free)/100, 0.01))
+
+	status := &models.Status{
+		CameraUp:         a.camStatus(),
+		Mode:             a.recordingMode(),
+		Recording:        recordStat,
+		UploaderUp:       a.uploader != nil,
+		Uploading:        uploadStat,
+		UploadThroughput: throughput,
+		UploadWindowOpen: windowOpen,
+		DiskUsage:        availPercentage,
+		MicUp:            a.mic.MicStatus(),
+		Listening:        a.mic.ListeningStatus(),
+		Audio:            a.mic.Levels(),
+	}
 
-	return &models.Status{
-		CameraUp:  a.camera.CamStatus(),
-		Recording: recordStat,
-		Uploading: uploadStat,
-		DiskUsage: availPercentage,
+	if a.uploader != nil && !windowOpen {
+		status.NextUploadWindow = &nextWindow
 	}
+
+	return status
 }
@@ -0,0 +1,154 @@
+package audio
+
+import (
+	"errors"
+	"math"
+	"pirecorder/logger"
+	"pirecorder/models"
+	"sync"
+	"time"
+)
+
+const (
+	minDb        = -120.0
+	clipLevel    = 0.999
+	levelBufSize = 16
+)
+
+// Meter computes RMS and peak levels over fixed windows of samples and keeps
+// track of clipping and prolonged silence while recording.
+type Meter struct {
+	lock         sync.Mutex
+	logger       *logger.Logger
+	windowSize   int
+	threshold    float64
+	timeout      time.Duration
+	sumSquares   float64
+	peak         float64
+	count        int
+	clipped      int
+	totalClipped int
+	lastSound    time.Time
+	warned       bool
+	levels       models.AudioLevels
+	listeners    map[chan models.AudioLevels]struct{}
+}
+
+func NewMeter(logger *logger.Logger, sampleRate int, window time.Duration, threshold float64, timeout time.Duration) *Meter {
+	windowSize := int(float64(sampleRate) * window.Seconds())
+
+	if windowSize <= 0 {
+		windowSize = sampleRate / 10
+	}
+
+	return &Meter{
+		logger:     logger,
+		windowSize: windowSize,
+		threshold:  threshold,
+		timeout:    timeout,
+		lastSound:  time.Now(),
+		levels:     models.AudioLevels{RMSDb: minDb, PeakDb: minDb},
+		listeners:  make(map[chan models.AudioLevels]struct{}),
+	}
+}
+
+// Reset clears the running totals, it should be called whenever a new recording starts.
+func (m *Meter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.sumSquares, m.peak, m.count, m.clipped, m.totalClipped = 0, 0, 0, 0, 0
+	m.lastSound = time.Now()
+	m.warned = false
+	m.levels = models.AudioLevels{RMSDb: minDb, PeakDb: minDb}
+}
+
+func (m *Meter) Process(samples []float32) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, sample := range samples {
+		abs := math.Abs(float64(sample))
+		m.sumSquares += abs * abs
+
+		if abs > m.peak {
+			m.peak = abs
+		}
+
+		if abs >= clipLevel {
+			m.clipped++
+		}
+
+		m.count++
+
+		if m.count >= m.windowSize {
+			m.publish()
+		}
+	}
+}
+
+// publish must be called with the lock held.
+func (m *Meter) publish() {
+	rms := math.Sqrt(m.sumSquares / float64(m.count))
+	m.totalClipped += m.clipped
+
+	levels := models.AudioLevels{
+		RMS:            rms,
+		Peak:           m.peak,
+		RMSDb:          toDb(rms),
+		PeakDb:         toDb(m.peak),
+		ClippedSamples: m.clipped,
+		TotalClipped:   m.totalClipped,
+	}
+
+	now := time.Now()
+
+	if levels.RMSDb >= m.threshold {
+		m.lastSound = now
+		m.warned = false
+	} else if silentFor := now.Sub(m.lastSound); silentFor >= m.timeout {
+		levels.Silent = true
+		if !m.warned {
+			m.logger.LogWarning(errors.New("no audio input"), "Microphone input has been silent", "silent_for", silentFor.String())
+			m.warned = true
+		}
+	}
+
+	m.levels = levels
+	m.sumSquares, m.peak, m.count, m.clipped = 0, 0, 0, 0
+
+	for listener := range m.listeners {
+		select {
+		case listener <- levels:
+		default:
+		}
+	}
+}
+
+func (m *Meter) Levels() models.AudioLevels {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.levels
+}
+
+func (m *Meter) Subscribe() chan models.AudioLevels {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	listener := make(chan models.AudioLevels, levelBufSize)
+	m.listeners[listener] = struct{}{}
+	return listener
+}
+
+func (m *Meter) Unsubscribe(listener chan models.AudioLevels) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.listeners, listener)
+}
+
+func toDb(value float64) float64 {
+	if value <= 0 {
+		return minDb
+	}
+	return math.Max(20*math.Log10(value), minDb)
+}
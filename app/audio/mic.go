@@ -7,17 +7,46 @@ import (
 	"pirecorder/apperror"
 	"pirecorder/config"
 	"pirecorder/logger"
+	"pirecorder/models"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jfreymuth/pulse"
+	"github.com/jfreymuth/pulse/proto"
+)
+
+var (
+	// ErrMicUnavailable is returned when the pulse server or the microphone can't be reached.
+	ErrMicUnavailable = errors.New("mic not available")
+	// ErrSourceNotFound is returned when selecting a source the pulse server doesn't know.
+	ErrSourceNotFound = errors.New("audio source not found")
+)
+
+// recordings are written as mono 32-bit float samples
+const (
+	recordSampleRate    = 44100
+	recordBitsPerSample = 32
+	recordChannels      = 1
 )
 
 type Mic struct {
+	lock        sync.Mutex // guards the state below, the monitor and the recorders change it from their goroutines
 	isMicUp     bool
 	isRecording bool
 	filename    string
 	logger      *logger.Logger
-	recorder    *pulse.Client
-	audioClose  chan struct{}
+	conn        *connection
+	audioClose  chan struct{} // closed to stop the current recording, nil once it was
+	audioDone   chan struct{} // closed once the current recording's file is closed
+	meter       *Meter
+	isListening bool
+	listenClose chan struct{}
+	broadcast   *broadcaster
+	source      *pulse.Source
+	sourceName  string
+	firstSample atomic.Pointer[time.Time] // set from the pulse callback, nil until the first chunk
+	onFinished  func(filename string)
 }
 
 func NewMic(logger *logger.Logger) (*Mic, error) {
@@ -43,22 +72,242 @@ func NewMic(logger *logger.Logger) (*Mic, error) {
 		logger.LogError(err, "Error creating pulse client, mic probably not available")
 		isMicUp = false
 	}
-	return &Mic{
-		isMicUp:  isMicUp,
-		logger:   logger,
-		recorder: client,
-	}, nil
+	audioConfig := config.GetConfig().AudioConfig
+
+	if !isMicUp {
+		client = nil
+	}
+
+	mic := &Mic{
+		isMicUp: isMicUp,
+		logger:  logger,
+		conn:    newConnection(client),
+		meter:   NewMeter(logger, 44100, audioConfig.LevelWindow, audioConfig.SilenceThreshold, audioConfig.SilenceTimeout),
+	}
+	mic.broadcast = newBroadcaster(logger, client, mic.recordOptions)
+
+	if isMicUp && audioConfig.Source != "" {
+		if err = mic.SetSource(audioConfig.Source); err != nil {
+			logger.LogError(err, "Configured audio source not available, using default source", "source", audioConfig.Source)
+		}
+	}
+
+	go mic.monitor()
+
+	return mic, nil
+}
+
+func (m *Mic) recordOptions() []pulse.RecordOption {
+	source, _ := m.selectedSource()
+
+	if source == nil {
+		return nil
+	}
+	return []pulse.RecordOption{pulse.RecordSource(source)}
+}
+
+// selectedSource returns the source set with SetSource, nil and an empty name for the default source.
+func (m *Mic) selectedSource() (*pulse.Source, string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.source, m.sourceName
+}
+
+func (m *Mic) setMicUp(up bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.isMicUp = up
+}
+
+func (m *Mic) ListSources() ([]models.AudioDevice, error) {
+	if !m.MicStatus() {
+		return nil, ErrMicUnavailable
+	}
+
+	client, _, _ := m.conn.get()
+
+	if client == nil {
+		return nil, ErrMicUnavailable
+	}
+
+	sources, err := client.ListSources()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var selected string
+
+	if source, _ := m.selectedSource(); source != nil {
+		selected = source.ID()
+	} else if source, err := client.DefaultSource(); err == nil {
+		selected = source.ID()
+	}
+
+	devices := make([]models.AudioDevice, 0, len(sources))
+
+	for _, source := range sources {
+		devices = append(devices, models.AudioDevice{
+			Name:        source.ID(),
+			Description: source.Name(),
+			Channels:    len(source.Channels()),
+			SampleRate:  source.SampleRate(),
+			Selected:    source.ID() == selected,
+		})
+	}
+
+	return devices, nil
+}
+
+// SetSource selects the pulse source new recordings and streams are opened against,
+// an empty name goes back to the server's default source.
+func (m *Mic) SetSource(name string) error {
+	if !m.MicStatus() {
+		return ErrMicUnavailable
+	}
+
+	if name == "" {
+		m.lock.Lock()
+		m.source = nil
+		m.sourceName = ""
+		m.lock.Unlock()
+		m.logger.LogInfo("Using default audio source")
+		return nil
+	}
+
+	client, _, _ := m.conn.get()
+
+	if client == nil {
+		return ErrMicUnavailable
+	}
+
+	source, err := client.SourceByID(name)
+
+	if errors.Is(err, proto.ErrNoSuchEntity) {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	m.source = source
+	m.sourceName = name
+	m.lock.Unlock()
+	m.logger.LogInfo("Selected audio source", "source", name)
+	return nil
+}
+
+func (m *Mic) MicStatus() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.isMicUp
+}
+
+func (m *Mic) Levels() *models.AudioLevels {
+	m.lock.Lock()
+	active := m.isRecording || m.isListening
+	m.lock.Unlock()
+
+	if m.meter == nil || !active {
+		return nil
+	}
+	levels := m.meter.Levels()
+	return &levels
+}
+
+func (m *Mic) RecordingStats() (bool, string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.filename == "" {
+		return m.isRecording, ""
+	}
+	return m.isRecording, fmt.Sprintf("%s.wav", m.filename)
+}
+
+// setRecording records the file being written, an empty filename when there is none.
+func (m *Mic) setRecording(filename string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.isRecording = filename != ""
+	m.filename = filename
+}
+
+// FirstSampleTime returns the capture time of the first sample of the current recording,
+// it is zero until pulse delivers the first chunk.
+func (m *Mic) FirstSampleTime() time.Time {
+	if first := m.firstSample.Load(); first != nil {
+		return *first
+	}
+	return time.Time{}
+}
+
+// RecordingInfo describes the format recordings are written in.
+func (m *Mic) RecordingInfo() models.AudioInfo {
+	return models.AudioInfo{
+		Format:        "pcm_f32le",
+		SampleRate:    recordSampleRate,
+		BitsPerSample: recordBitsPerSample,
+		Channels:      recordChannels,
+	}
+}
+
+// OnClipFinished registers a function called with the file name of every
+// voice activated clip once it has been closed.
+func (m *Mic) OnClipFinished(handler func(filename string)) {
+	m.onFinished = handler
+}
+
+func (m *Mic) ListeningStatus() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.isListening
+}
+
+func (m *Mic) StartLevelStream() (chan models.AudioLevels, chan struct{}, error) {
+	if !m.MicStatus() {
+		return nil, nil, ErrMicUnavailable
+	}
+	m.logger.LogInfo("Starting audio level stream")
+	levelChan := m.meter.Subscribe()
+	closeChan := make(chan struct{})
+
+	go func() {
+		<-closeChan
+		m.logger.LogInfo("Closing audio level stream")
+		m.meter.Unsubscribe(levelChan)
+	}()
+
+	return levelChan, closeChan, nil
 }
 
 func (m *Mic) StartRecording(filename string) error {
-	if !m.isMicUp {
-		return errors.New("mic not available")
+	if !m.MicStatus() {
+		return ErrMicUnavailable
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.isListening {
+		return apperror.ServiceUnavailable.SetMessage("Cannot start recording while voice activated recording is running")
 	}
+
+	if m.isRecording && m.audioClose == nil {
+		// the previous recording was stopped, wait for its file to be closed
+		done := m.audioDone
+		m.lock.Unlock()
+		<-done
+		m.lock.Lock()
+	}
+
 	if m.isRecording {
-		m.isRecording = false
+		return apperror.ServiceUnavailable.SetMessage("Cannot start recording while recording is in progress")
 	}
 
-	file, err := NewFile(fmt.Sprintf("%s/%s.wav", config.GetConfig().AudiosFolder, filename), 44100, 32, 1)
+	file, err := NewFile(fmt.Sprintf("%s/%s.wav", config.GetConfig().AudiosFolder, filename), recordSampleRate, recordBitsPerSample, recordChannels)
 
 	if err != nil {
 		m.logger.LogError(err, "Error creating audio file", "filename", filename)
@@ -68,31 +317,104 @@ func (m *Mic) StartRecording(filename string) error {
 	m.isRecording = true
 	m.filename = filename
 	m.audioClose = make(chan struct{})
+	m.audioDone = make(chan struct{})
+	m.firstSample.Store(nil)
+	m.meter.Reset()
 
-	go func() {
+	go func(stop, done chan struct{}) {
 		defer func() {
 			_ = file.Close()
-			m.isRecording = false
-			m.filename = ""
+			m.setRecording("")
+			close(done)
 		}()
-		stream, err := m.recorder.NewRecord(pulse.Float32Writer(file.WriteSamples))
-
-		if err != nil {
-			m.logger.LogError(err, "Error creating pulse stream")
-			return
-		}
-
-		stream.Start()
+		writer := pulse.Float32Writer(func(samples []float32) (int, error) {
+			if m.firstSample.Load() == nil {
+				// the chunk ends now, so the first sample was captured one chunk earlier
+				first := time.Now().Add(-time.Duration(len(samples)) * time.Second / 44100)
+				m.firstSample.Store(&first)
+			}
+			m.meter.Process(samples)
+			return file.WriteSamples(samples)
+		})
 
-		<-m.audioClose
-		stream.Stop()
-	}()
+		m.runStream(writer, stop, func(gap time.Duration) {
+			// pad the gap with silence so the audio stays aligned with the video
+			if err := file.WriteSilence(int(gap.Seconds() * 44100)); err != nil {
+				m.logger.LogError(err, "Error writing silence for audio gap", "filename", filename)
+			}
+		})
+	}(m.audioClose, m.audioDone)
 	return nil
 }
 
 func (m *Mic) StopRecording() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	m.logger.LogInfo("Stopping audio recording", "filename", m.filename)
-	if m.isRecording {
+	if m.isRecording && !m.isListening && m.audioClose != nil {
 		close(m.audioClose)
+		m.audioClose = nil // the file is still being closed, stopping again is a no-op
+	}
+}
+
+// StartListening records continuously from the mic, only writing to disk
+// while voice activity is detected.
+func (m *Mic) StartListening() error {
+	if !m.MicStatus() {
+		return ErrMicUnavailable
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.isRecording {
+		return apperror.ServiceUnavailable.SetMessage("Cannot start voice activated recording while recording is in progress")
+	}
+	if m.isListening {
+		return nil
+	}
+
+	trig := newTrigger(m.logger, 44100, config.GetConfig().AudioConfig)
+	trig.onOpen = func(filename string) {
+		m.setRecording(filename)
+	}
+	trig.onClose = func(filename string) {
+		m.setRecording("")
+		if m.onFinished != nil {
+			m.onFinished(fmt.Sprintf("%s.wav", filename))
+		}
+	}
+
+	m.logger.LogInfo("Starting voice activated recording")
+	m.isListening = true
+	m.listenClose = make(chan struct{})
+	m.meter.Reset()
+
+	go func(done chan struct{}) {
+		defer func() {
+			trig.close()
+			m.lock.Lock()
+			m.isListening = false
+			m.lock.Unlock()
+		}()
+		writer := pulse.Float32Writer(func(samples []float32) (int, error) {
+			m.meter.Process(samples)
+			return trig.WriteSamples(samples)
+		})
+
+		m.runStream(writer, done, func(time.Duration) { trig.close() })
+	}(m.listenClose)
+	return nil
+}
+
+func (m *Mic) StopListening() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.logger.LogInfo("Stopping voice activated recording")
+	if m.isListening && m.listenClose != nil {
+		close(m.listenClose)
+		m.listenClose = nil // the last clip is still being closed, stopping again is a no-op
 	}
 }
@@ -0,0 +1,223 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"pirecorder/config"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfreymuth/pulse"
+)
+
+// connection tracks the pulse client currently in use. lost is closed when the
+// client or the selected source goes away, restored is closed once they are back.
+type connection struct {
+	lock     sync.Mutex
+	client   *pulse.Client
+	lost     chan struct{}
+	restored chan struct{}
+}
+
+func newConnection(client *pulse.Client) *connection {
+	c := &connection{
+		client:   client,
+		lost:     make(chan struct{}),
+		restored: make(chan struct{}),
+	}
+
+	if client == nil {
+		close(c.lost)
+	} else {
+		close(c.restored)
+	}
+
+	return c
+}
+
+func (c *connection) get() (*pulse.Client, chan struct{}, chan struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.client, c.lost, c.restored
+}
+
+func (c *connection) markLost() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.lost:
+		return
+	default:
+	}
+
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+	close(c.lost)
+	c.restored = make(chan struct{})
+}
+
+func (c *connection) markRestored(client *pulse.Client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.client = client
+	c.lost = make(chan struct{})
+	close(c.restored)
+}
+
+// monitor periodically checks that the pulse server and the selected source are
+// reachable, and reconnects once they come back.
+func (m *Mic) monitor() {
+	ticker := time.NewTicker(config.GetConfig().AudioConfig.ReconnectInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		client, lost, _ := m.conn.get()
+
+		select {
+		case <-lost:
+			m.reconnect()
+			continue
+		default:
+		}
+
+		if err := m.checkSource(client); err != nil {
+			_, name := m.selectedSource()
+			m.logger.LogError(err, "Microphone lost, waiting for it to come back", "source", name)
+			m.setMicUp(false)
+			m.conn.markLost()
+		}
+	}
+}
+
+// checkSource returns an error unless the selected source, or the server's default
+// source, is there. Monitor sources of outputs are always there, so a default source
+// that is one means no microphone is plugged in.
+func (m *Mic) checkSource(client *pulse.Client) error {
+	if _, name := m.selectedSource(); name != "" {
+		_, err := client.SourceByID(name)
+		return err
+	}
+
+	source, err := client.DefaultSource()
+
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(source.ID(), ".monitor") {
+		return fmt.Errorf("default audio source %s is an output monitor, no microphone available", source.ID())
+	}
+
+	return nil
+}
+
+func (m *Mic) reconnect() {
+	client, err := pulse.NewClient()
+
+	if err != nil {
+		return
+	}
+
+	if err = m.checkSource(client); err != nil {
+		client.Close()
+		return
+	}
+
+	m.lock.Lock()
+	if m.sourceName != "" {
+		m.source, _ = client.SourceByID(m.sourceName)
+	}
+	name := m.sourceName
+	m.isMicUp = true
+	m.lock.Unlock()
+
+	m.conn.markRestored(client)
+	m.broadcast.reset(client)
+	m.logger.LogInfo("Microphone reconnected", "source", name)
+}
+
+// sampleClock passes samples on to a writer, remembering when the last ones arrived.
+type sampleClock struct {
+	pulse.Writer
+	lock sync.Mutex
+	last time.Time
+}
+
+func (c *sampleClock) Write(buf []byte) (int, error) {
+	c.lock.Lock()
+	c.last = time.Now()
+	c.lock.Unlock()
+	return c.Writer.Write(buf)
+}
+
+// lastSample returns when samples last arrived, or fallback if none did yet.
+func (c *sampleClock) lastSample(fallback time.Time) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.last.IsZero() {
+		return fallback
+	}
+	return c.last
+}
+
+// runStream feeds the writer from a pulse record stream until done is closed.
+// If the mic goes away in the meantime it waits for it to come back and opens a
+// new stream, calling onGap with the time since the last samples were received.
+func (m *Mic) runStream(writer pulse.Writer, done chan struct{}, onGap func(time.Duration)) {
+	clock := &sampleClock{Writer: writer}
+
+	for {
+		client, lost, _ := m.conn.get()
+
+		var stream *pulse.RecordStream
+
+		if client != nil {
+			var err error
+			stream, err = client.NewRecord(clock, m.recordOptions()...)
+
+			if err != nil {
+				m.logger.LogError(err, "Error creating pulse stream")
+				m.setMicUp(false)
+				m.conn.markLost()
+			} else {
+				stream.Start()
+			}
+		}
+
+		select {
+		case <-done:
+			if stream != nil {
+				stream.Stop()
+				stream.Close()
+			}
+			return
+		case <-lost:
+			if stream != nil {
+				stream.Close()
+			}
+		}
+
+		lostAt := time.Now()
+		_, _, restored := m.conn.get()
+
+		select {
+		case <-done:
+			return
+		case <-restored:
+		}
+
+		// samples stop arriving before the loss is detected, which takes up to a check interval
+		gap := time.Since(clock.lastSample(lostAt))
+		m.logger.LogWarning(errors.New("audio gap"), "Resuming audio after microphone reconnected", "gap", gap.String())
+
+		if onGap != nil {
+			onGap(gap)
+		}
+	}
+}
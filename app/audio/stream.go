@@ -0,0 +1,304 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"pirecorder/logger"
+	"sync"
+
+	"github.com/jfreymuth/pulse"
+)
+
+const listenerBufSize = 64
+
+// broadcaster shares a single pulse record stream between all live listeners.
+// The stream is opened for the first listener and closed once the last one leaves,
+// it is independent of the stream used for recording to disk.
+type broadcaster struct {
+	lock       sync.Mutex // guards listeners, it is the only lock taken by write
+	streamLock sync.Mutex // guards stream, it is never held while write runs
+	logger     *logger.Logger
+	client     *pulse.Client
+	opts       func() []pulse.RecordOption
+	stream     *pulse.RecordStream
+	listeners  map[chan []byte]struct{}
+}
+
+func newBroadcaster(logger *logger.Logger, client *pulse.Client, opts func() []pulse.RecordOption) *broadcaster {
+	return &broadcaster{
+		logger:    logger,
+		client:    client,
+		opts:      opts,
+		listeners: make(map[chan []byte]struct{}),
+	}
+}
+
+func (b *broadcaster) subscribe() (chan []byte, error) {
+	b.streamLock.Lock()
+	defer b.streamLock.Unlock()
+
+	listener := make(chan []byte, listenerBufSize)
+
+	b.lock.Lock()
+	b.listeners[listener] = struct{}{}
+	b.lock.Unlock()
+
+	if b.stream == nil {
+		if b.client == nil {
+			b.lock.Lock()
+			delete(b.listeners, listener)
+			b.lock.Unlock()
+			return nil, ErrMicUnavailable
+		}
+
+		stream, err := b.client.NewRecord(pulse.Float32Writer(b.write), b.opts()...)
+
+		if err != nil {
+			b.lock.Lock()
+			delete(b.listeners, listener)
+			b.lock.Unlock()
+			return nil, err
+		}
+
+		b.logger.LogInfo("Opening shared audio stream")
+		stream.Start()
+		b.stream = stream
+	}
+
+	return listener, nil
+}
+
+func (b *broadcaster) unsubscribe(listener chan []byte) {
+	b.streamLock.Lock()
+	defer b.streamLock.Unlock()
+
+	b.lock.Lock()
+	delete(b.listeners, listener)
+	remaining := len(b.listeners)
+	b.lock.Unlock()
+
+	if remaining == 0 && b.stream != nil {
+		b.logger.LogInfo("Closing shared audio stream")
+		b.stream.Stop()
+		b.stream.Close()
+		b.stream = nil
+	}
+}
+
+// reset swaps in a reconnected client, reopening the stream for any listeners still waiting.
+func (b *broadcaster) reset(client *pulse.Client) {
+	b.streamLock.Lock()
+	defer b.streamLock.Unlock()
+
+	if b.stream != nil {
+		b.stream.Close()
+		b.stream = nil
+	}
+	b.client = client
+
+	b.lock.Lock()
+	remaining := len(b.listeners)
+	b.lock.Unlock()
+
+	if remaining == 0 {
+		return
+	}
+
+	stream, err := b.client.NewRecord(pulse.Float32Writer(b.write), b.opts()...)
+
+	if err != nil {
+		b.logger.LogError(err, "Error reopening shared audio stream")
+		return
+	}
+
+	stream.Start()
+	b.stream = stream
+}
+
+// write hands every listener its own copy of the samples, slow listeners miss chunks
+// instead of holding up the others.
+func (b *broadcaster) write(samples []float32) (int, error) {
+	chunk := make([]byte, 4*len(samples))
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint32(chunk[i*4:], math.Float32bits(sample))
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for listener := range b.listeners {
+		select {
+		case listener <- chunk:
+		default:
+		}
+	}
+	return len(samples), nil
+}
+
+// StreamContentType returns the content type served for the given stream format.
+func StreamContentType(format string) (string, error) {
+	switch format {
+	case "", "wav":
+		return "audio/wav", nil
+	case "opus":
+		return "audio/ogg", nil
+	case "mp3":
+		return "audio/mpeg", nil
+	default:
+		return "", fmt.Errorf("unsupported stream format %q", format)
+	}
+}
+
+func (m *Mic) StartAudioStream(format string) (chan []byte, chan struct{}, error) {
+	if !m.MicStatus() {
+		return nil, nil, ErrMicUnavailable
+	}
+
+	if _, err := StreamContentType(format); err != nil {
+		return nil, nil, err
+	}
+
+	var encoder *exec.Cmd
+
+	if format == "opus" || format == "mp3" {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return nil, nil, fmt.Errorf("no encoder available for %s", format)
+		}
+		encoder = newEncoder(format)
+	}
+
+	pcm, err := m.broadcast.subscribe()
+
+	if err != nil {
+		m.logger.LogError(err, "Error creating pulse stream")
+		return nil, nil, err
+	}
+
+	m.logger.LogInfo("Starting audio stream", "format", format)
+	streamChan := make(chan []byte, listenerBufSize)
+	closeChan := make(chan struct{})
+
+	if encoder == nil {
+		go m.streamWav(pcm, streamChan, closeChan)
+		return streamChan, closeChan, nil
+	}
+
+	if err = m.streamEncoded(encoder, pcm, streamChan, closeChan); err != nil {
+		m.broadcast.unsubscribe(pcm)
+		return nil, nil, err
+	}
+
+	return streamChan, closeChan, nil
+}
+
+func (m *Mic) streamWav(pcm chan []byte, streamChan chan []byte, closeChan chan struct{}) {
+	defer func() {
+		m.broadcast.unsubscribe(pcm)
+		close(streamChan)
+		m.logger.LogInfo("Closing audio stream")
+	}()
+
+	var header bytes.Buffer
+	_ = writeHeaders(&header, 44100, 32, 1, unknownSize)
+	streamChan <- header.Bytes()
+
+	for {
+		select {
+		case <-closeChan:
+			return
+		case chunk := <-pcm:
+			select {
+			case streamChan <- chunk:
+			case <-closeChan:
+				return
+			}
+		}
+	}
+}
+
+func (m *Mic) streamEncoded(encoder *exec.Cmd, pcm chan []byte, streamChan chan []byte, closeChan chan struct{}) error {
+	stdin, err := encoder.StdinPipe()
+
+	if err != nil {
+		return err
+	}
+
+	stdout, err := encoder.StdoutPipe()
+
+	if err != nil {
+		return err
+	}
+
+	if err = encoder.Start(); err != nil {
+		m.logger.LogError(err, "Error starting audio encoder")
+		return err
+	}
+
+	go func() {
+		defer func() {
+			m.broadcast.unsubscribe(pcm)
+			_ = stdin.Close()
+		}()
+
+		for {
+			select {
+			case <-closeChan:
+				return
+			case chunk := <-pcm:
+				if _, err := stdin.Write(chunk); err != nil {
+					m.logger.LogError(err, "Error writing to audio encoder")
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer func() {
+			_ = encoder.Process.Kill()
+			_ = encoder.Wait()
+			close(streamChan)
+			m.logger.LogInfo("Closing audio stream")
+		}()
+
+		for {
+			buf := make([]byte, 4096)
+			n, err := stdout.Read(buf)
+
+			if n > 0 {
+				select {
+				case streamChan <- buf[:n]:
+				case <-closeChan:
+					return
+				}
+			}
+
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					m.logger.LogError(err, "Error reading from audio encoder")
+				}
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func newEncoder(format string) *exec.Cmd {
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "f32le", "-ar", "44100", "-ac", "1", "-i", "-"}
+
+	switch format {
+	case "opus":
+		args = append(args, "-c:a", "libopus", "-b:a", "64k", "-f", "ogg", "-")
+	case "mp3":
+		args = append(args, "-c:a", "libmp3lame", "-b:a", "128k", "-f", "mp3", "-")
+	}
+
+	return exec.Command("ffmpeg", args...)
+}
@@ -0,0 +1,142 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"time"
+)
+
+// trigger opens a new wav file whenever the input energy rises above the
+// threshold and closes it once the input has been quiet for the hang time.
+// A short pre-roll of samples is kept so the start of the sound isn't lost.
+type trigger struct {
+	logger    *logger.Logger
+	threshold float64
+	hangTime  time.Duration
+	preRoll   []float32
+	preSize   int
+	file      *File
+	filename  string
+	lastSound time.Time
+	onOpen    func(filename string)
+	onClose   func(filename string)
+}
+
+func newTrigger(logger *logger.Logger, sampleRate int, audioConfig config.Audio) *trigger {
+	preSize := int(float64(sampleRate) * audioConfig.VADPreRoll.Seconds())
+
+	return &trigger{
+		logger:    logger,
+		threshold: audioConfig.VADThreshold,
+		hangTime:  audioConfig.VADHangTime,
+		preSize:   preSize,
+		preRoll:   make([]float32, 0, preSize),
+	}
+}
+
+func (t *trigger) WriteSamples(samples []float32) (int, error) {
+	if len(samples) == 0 {
+		return 0, nil
+	}
+
+	active := toDb(rms(samples)) >= t.threshold
+	now := time.Now()
+
+	if t.file == nil {
+		if !active {
+			t.bufferSamples(samples)
+			return len(samples), nil
+		}
+
+		if err := t.open(now); err != nil {
+			t.logger.LogError(err, "Error creating triggered audio file", "filename", t.filename)
+			t.bufferSamples(samples)
+			return len(samples), nil
+		}
+	}
+
+	if active {
+		t.lastSound = now
+	}
+
+	if _, err := t.file.WriteSamples(samples); err != nil {
+		t.logger.LogError(err, "Error writing triggered audio file", "filename", t.filename)
+	}
+
+	if !active && now.Sub(t.lastSound) >= t.hangTime {
+		t.close()
+	}
+
+	return len(samples), nil
+}
+
+func (t *trigger) open(now time.Time) error {
+	filename := fmt.Sprintf("vad_%s", now.Format("2006-01-02_15-04-05.000"))
+	file, err := NewFile(fmt.Sprintf("%s/%s.wav", config.GetConfig().AudiosFolder, filename), 44100, 32, 1)
+
+	if err != nil {
+		t.filename = filename
+		return err
+	}
+
+	t.file = file
+	t.filename = filename
+	t.lastSound = now
+	t.logger.LogInfo("Voice activity detected, starting triggered recording", "filename", filename)
+
+	if _, err = t.file.WriteSamples(t.preRoll); err != nil {
+		t.logger.LogError(err, "Error writing pre-roll samples", "filename", filename)
+	}
+	t.preRoll = t.preRoll[:0]
+
+	if t.onOpen != nil {
+		t.onOpen(filename)
+	}
+
+	return nil
+}
+
+func (t *trigger) close() {
+	if t.file == nil {
+		return
+	}
+
+	if err := t.file.Close(); err != nil {
+		t.logger.LogError(err, "Error closing triggered audio file", "filename", t.filename)
+	}
+
+	t.logger.LogInfo("Voice activity ended, closed triggered recording", "filename", t.filename)
+
+	if t.onClose != nil {
+		t.onClose(t.filename)
+	}
+
+	t.file = nil
+	t.filename = ""
+}
+
+func (t *trigger) bufferSamples(samples []float32) {
+	if t.preSize == 0 {
+		return
+	}
+
+	if len(samples) >= t.preSize {
+		t.preRoll = append(t.preRoll[:0], samples[len(samples)-t.preSize:]...)
+		return
+	}
+
+	if overflow := len(t.preRoll) + len(samples) - t.preSize; overflow > 0 {
+		t.preRoll = append(t.preRoll[:0], t.preRoll[overflow:]...)
+	}
+	t.preRoll = append(t.preRoll, samples...)
+}
+
+func rms(samples []float32) float64 {
+	var sum float64
+	for _, sample := range samples {
+		sum += float64(sample) * float64(sample)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 )
 
+// silenceChunkSamples is how many samples of silence are written at once, 64 KB of float32.
+const silenceChunkSamples = 16 * 1024
+
 type File struct {
 	file *os.File
 }
@@ -28,76 +31,89 @@ func NewFile(path string, sampleRate int, bitsPerSample int, numChannels int) (*
 }
 
 func (f *File) WriteHeaders(sampleRate int, bitsPerSample int, numChannels int) error {
+	return writeHeaders(f.file, sampleRate, bitsPerSample, numChannels, 0) // Sizes to be filled in later
+}
+
+// unknownSize is used for the size fields of open-ended streams.
+const unknownSize = 0xFFFFFFFF
+
+func writeHeaders(w io.Writer, sampleRate int, bitsPerSample int, numChannels int, dataSize uint32) error {
 	var err error
-	err = binary.Write(f.file, binary.LittleEndian, []byte("RIFF"))
+	riffSize := dataSize + 36
+
+	if dataSize == unknownSize {
+		riffSize = unknownSize
+	}
+
+	err = binary.Write(w, binary.LittleEndian, []byte("RIFF"))
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint32(0)) // File size To be filled in later
+	err = binary.Write(w, binary.LittleEndian, riffSize) // File size
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, []byte("WAVE"))
+	err = binary.Write(w, binary.LittleEndian, []byte("WAVE"))
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, []byte("fmt "))
+	err = binary.Write(w, binary.LittleEndian, []byte("fmt "))
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint32(16)) // Chunk size
+	err = binary.Write(w, binary.LittleEndian, uint32(16)) // Chunk size
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint16(3)) // PCM
+	err = binary.Write(w, binary.LittleEndian, uint16(3)) // PCM
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint16(numChannels)) // Mono
+	err = binary.Write(w, binary.LittleEndian, uint16(numChannels)) // Mono
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint32(sampleRate)) // Sample rate
+	err = binary.Write(w, binary.LittleEndian, uint32(sampleRate)) // Sample rate
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint32(sampleRate*bitsPerSample*numChannels/8)) // Byte rate
+	err = binary.Write(w, binary.LittleEndian, uint32(sampleRate*bitsPerSample*numChannels/8)) // Byte rate
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint16(bitsPerSample*numChannels/8)) // Block align
+	err = binary.Write(w, binary.LittleEndian, uint16(bitsPerSample*numChannels/8)) // Block align
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint16(bitsPerSample)) // Bits per sample
+	err = binary.Write(w, binary.LittleEndian, uint16(bitsPerSample)) // Bits per sample
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, []byte("data"))
+	err = binary.Write(w, binary.LittleEndian, []byte("data"))
 
 	if err != nil {
 		return err
 	}
 
-	err = binary.Write(f.file, binary.LittleEndian, uint32(0)) // Data size To be filled in later
+	err = binary.Write(w, binary.LittleEndian, dataSize) // Data size
 
 	if err != nil {
 		return err
@@ -110,6 +126,26 @@ func (f *File) WriteSamples(samples []float32) (int, error) {
 	return len(samples), binary.Write(f.file, binary.LittleEndian, samples)
 }
 
+// WriteSilence writes numSamples zero samples in chunks, so a long gap doesn't need
+// to be held in memory at once.
+func (f *File) WriteSilence(numSamples int) error {
+	chunk := make([]float32, silenceChunkSamples)
+
+	for numSamples > 0 {
+		n := numSamples
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+
+		if err := binary.Write(f.file, binary.LittleEndian, chunk[:n]); err != nil {
+			return err
+		}
+		numSamples -= n
+	}
+
+	return nil
+}
+
 // [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]
 // [R, I, F, F, 0, 0, 0, 0, W, A, V, E, f, m, t, 0x20, 0x10, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x44, 0xAC, 0x00, 0x00, 0x10, 0xB1, 0x02, 0x00, 0x04, 0x00, 0x10, 0x00, d, a, t, a, 0, 0, 0, 0]
 func (f *File) Close() error {
@@ -136,5 +172,5 @@ func (f *File) Close() error {
 		return err
 	}
 
-	return nil
+	return f.file.Close()
 }
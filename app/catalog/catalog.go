@@ -0,0 +1,324 @@
+package catalog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/models"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	StatusRecording = "recording"
+	StatusStopped   = "stopped"
+	StatusUploading = "uploading"
+	StatusUploaded  = "uploaded"
+	StatusDeleted   = "deleted"
+)
+
+var (
+	ErrNotFound = errors.New("recording not found")
+
+	recordingsBucket = []byte("recordings") // recording ID to JSON encoded models.Recording
+	filesBucket      = []byte("files")      // file name to the ID of the recording it belongs to
+)
+
+// Catalog keeps every recording session by ID in an embedded database.
+type Catalog struct {
+	db *bolt.DB
+}
+
+func NewCatalog(path string) (*Catalog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{recordingsBucket, filesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	c := &Catalog{db: db}
+
+	if err = c.importJSON(filepath.Join(filepath.Dir(path), "recordings.json")); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// importJSON moves recordings from the JSON file older versions kept them in.
+func (c *Catalog) importJSON(path string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var recordings map[string]*models.Recording
+
+	if err = json.Unmarshal(data, &recordings); err != nil {
+		return err
+	}
+
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		for _, recording := range recordings {
+			if err := put(tx, recording); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path, path+".imported")
+}
+
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// NewID returns a random recording ID.
+func NewID() (string, error) {
+	id := make([]byte, 8)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func (c *Catalog) Add(recording models.Recording) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, &recording)
+	})
+}
+
+func (c *Catalog) Get(id string) (models.Recording, bool) {
+	var (
+		recording models.Recording
+		found     bool
+	)
+
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		rec, err := get(tx, id)
+		if err == nil {
+			recording, found = *rec, true
+		}
+		return nil
+	})
+
+	return recording, found
+}
+
+// ByFile returns the recording a file belongs to.
+func (c *Catalog) ByFile(filename string) (models.Recording, bool) {
+	var (
+		recording models.Recording
+		found     bool
+	)
+
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(filesBucket).Get([]byte(filename))
+
+		if id == nil {
+			return nil
+		}
+
+		rec, err := get(tx, string(id))
+		if err == nil {
+			recording, found = *rec, true
+		}
+		return nil
+	})
+
+	return recording, found
+}
+
+// List returns every recording, newest first.
+func (c *Catalog) List() ([]models.Recording, error) {
+	var recordings []models.Recording
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordingsBucket).ForEach(func(_, data []byte) error {
+			var recording models.Recording
+
+			if err := json.Unmarshal(data, &recording); err != nil {
+				return err
+			}
+
+			recordings = append(recordings, recording)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].StartedAt.After(recordings[j].StartedAt) })
+	return recordings, nil
+}
+
+// Update applies change to a recording, it returns ErrNotFound for unknown IDs.
+func (c *Catalog) Update(id string, change func(recording *models.Recording)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		recording, err := get(tx, id)
+
+		if err != nil {
+			return err
+		}
+
+		if err = unindex(tx, recording); err != nil {
+			return err
+		}
+
+		change(recording)
+		return put(tx, recording)
+	})
+}
+
+// UpdateFile applies change to the file of whichever recording it belongs to,
+// files that belong to no recording are ignored.
+func (c *Catalog) UpdateFile(filename string, change func(file *models.RecordingFile)) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(filesBucket).Get([]byte(filename))
+
+		if id == nil {
+			return nil
+		}
+
+		recording, err := get(tx, string(id))
+
+		if err != nil {
+			return err
+		}
+
+		for i := range recording.Files {
+			if recording.Files[i].Filename == filename {
+				change(&recording.Files[i])
+			}
+		}
+
+		return put(tx, recording)
+	})
+}
+
+// Prune removes the recordings remove returns true for, along with their files, and
+// returns how many were removed.
+func (c *Catalog) Prune(remove func(recording models.Recording) bool) (int, error) {
+	removed := 0
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		var doomed []*models.Recording
+
+		err := tx.Bucket(recordingsBucket).ForEach(func(_, data []byte) error {
+			var recording models.Recording
+
+			if err := json.Unmarshal(data, &recording); err != nil {
+				return err
+			}
+
+			if remove(recording) {
+				doomed = append(doomed, &recording)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// buckets must not be changed while iterating over them
+		for _, recording := range doomed {
+			if err = unindex(tx, recording); err != nil {
+				return err
+			}
+
+			if err = tx.Bucket(recordingsBucket).Delete([]byte(recording.ID)); err != nil {
+				return err
+			}
+		}
+
+		removed = len(doomed)
+		return nil
+	})
+
+	return removed, err
+}
+
+func get(tx *bolt.Tx, id string) (*models.Recording, error) {
+	data := tx.Bucket(recordingsBucket).Get([]byte(id))
+
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	var recording models.Recording
+
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, err
+	}
+
+	return &recording, nil
+}
+
+// put stores a recording and indexes its files.
+func put(tx *bolt.Tx, recording *models.Recording) error {
+	data, err := json.Marshal(recording)
+
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(recordingsBucket).Put([]byte(recording.ID), data); err != nil {
+		return err
+	}
+
+	for _, file := range recording.Files {
+		if err = tx.Bucket(filesBucket).Put([]byte(file.Filename), []byte(recording.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unindex(tx *bolt.Tx, recording *models.Recording) error {
+	for _, file := range recording.Files {
+		if err := tx.Bucket(filesBucket).Delete([]byte(file.Filename)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
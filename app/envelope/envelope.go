@@ -0,0 +1,506 @@
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted files start with magic, followed by the length of a JSON header and the
+// header itself. The body is the plaintext split into chunks, each sealed with
+// AES-256-GCM under a per-file data key. Chunk nonces are derived from the header
+// nonce and the chunk index. The additional data of every chunk binds the header,
+// the chunk index and whether it is the last chunk, so swapped headers and truncated
+// files fail to decrypt.
+const (
+	magic     = "PIREC2\n"
+	Algorithm = "AES-256-GCM-CHUNKED"
+	ChunkSize = 64 * 1024
+
+	KeyAlgRSA     = "RSA-OAEP-SHA256"
+	KeyAlgKeyFile = "AES-256-GCM-KEYFILE"
+
+	// metadata keys stored alongside the uploaded object
+	MetaAlgorithm   = "enc-alg"
+	MetaKeyAlg      = "enc-key-alg"
+	MetaWrappedKey  = "enc-wrapped-key"
+	MetaNonce       = "enc-nonce"
+	MetaChunkSize   = "enc-chunk-size"
+	MetaContentType = "enc-content-type"
+)
+
+var oaepLabel = []byte("pirecorder")
+
+type Header struct {
+	Algorithm  string `json:"alg"`
+	KeyAlg     string `json:"keyAlg"`
+	WrappedKey string `json:"wrappedKey"`
+	Nonce      string `json:"nonce"`
+	ChunkSize  int    `json:"chunkSize"`
+}
+
+func (h Header) Metadata() map[string]string {
+	return map[string]string{
+		MetaAlgorithm:  h.Algorithm,
+		MetaKeyAlg:     h.KeyAlg,
+		MetaWrappedKey: h.WrappedKey,
+		MetaNonce:      h.Nonce,
+		MetaChunkSize:  fmt.Sprint(h.ChunkSize),
+	}
+}
+
+// Encrypter wraps per-file data keys with either an RSA public key or a local key file.
+type Encrypter struct {
+	keyAlg string
+	wrap   func(dataKey []byte) ([]byte, error)
+}
+
+// NewEncrypter returns nil when neither a public key nor a key file is configured.
+func NewEncrypter(publicKeyFile string, keyFile string) (*Encrypter, error) {
+	switch {
+	case publicKeyFile != "":
+		publicKey, err := loadPublicKey(publicKeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Encrypter{
+			keyAlg: KeyAlgRSA,
+			wrap: func(dataKey []byte) ([]byte, error) {
+				return rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, oaepLabel)
+			},
+		}, nil
+	case keyFile != "":
+		masterKey, err := loadKeyFile(keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Encrypter{
+			keyAlg: KeyAlgKeyFile,
+			wrap: func(dataKey []byte) ([]byte, error) {
+				return seal(masterKey, dataKey)
+			},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// EncryptFile writes an encrypted copy of src to dst and returns its header.
+func (e *Encrypter) EncryptFile(src string, dst string) (*Header, error) {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = in.Close() }()
+
+	out, err := os.Create(dst)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := e.encrypt(in, out)
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(dst)
+		return nil, err
+	}
+
+	return header, nil
+}
+
+func (e *Encrypter) encrypt(r io.Reader, w io.Writer) (*Header, error) {
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, 12)
+
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := e.wrap(dataKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{
+		Algorithm:  Algorithm,
+		KeyAlg:     e.keyAlg,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		ChunkSize:  ChunkSize,
+	}
+
+	headerData, err := writeHeader(w, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	headerSum := sha256.Sum256(headerData)
+
+	aead, err := newGCM(dataKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(r, ChunkSize)
+	chunk := make([]byte, ChunkSize)
+	sealed := make([]byte, 0, ChunkSize+aead.Overhead())
+
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(reader, chunk)
+
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		_, peekErr := reader.Peek(1)
+		final := peekErr != nil
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(nonce, index), chunk[:n], chunkAAD(headerSum[:], index, final))
+
+		if _, err = w.Write(sealed); err != nil {
+			return nil, err
+		}
+
+		if final {
+			return header, nil
+		}
+	}
+}
+
+// Decrypt restores a file encrypted by EncryptFile using the private key or key file at keyPath.
+func Decrypt(r io.Reader, w io.Writer, keyPath string) error {
+	reader := bufio.NewReaderSize(r, ChunkSize)
+	header, headerData, err := readHeader(reader)
+
+	if err != nil {
+		return err
+	}
+
+	headerSum := sha256.Sum256(headerData)
+
+	if header.Algorithm != Algorithm {
+		return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(header.WrappedKey)
+
+	if err != nil {
+		return err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(header.Nonce)
+
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := unwrap(header.KeyAlg, wrapped, keyPath)
+
+	if err != nil {
+		return fmt.Errorf("unwrapping data key: %w", err)
+	}
+
+	aead, err := newGCM(dataKey)
+
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, header.ChunkSize+aead.Overhead())
+	plain := make([]byte, 0, header.ChunkSize)
+
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(reader, chunk)
+
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, io.EOF) {
+				return errors.New("encrypted file is truncated")
+			}
+			return err
+		}
+
+		_, peekErr := reader.Peek(1)
+		final := peekErr != nil
+
+		plain, err = aead.Open(plain[:0], chunkNonce(nonce, index), chunk[:n], chunkAAD(headerSum[:], index, final))
+
+		if err != nil {
+			return fmt.Errorf("decrypting chunk %d: %w", index, err)
+		}
+
+		if _, err = w.Write(plain); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+func unwrap(keyAlg string, wrapped []byte, keyPath string) ([]byte, error) {
+	switch keyAlg {
+	case KeyAlgRSA:
+		privateKey, err := loadPrivateKey(keyPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrapped, oaepLabel)
+	case KeyAlgKeyFile:
+		masterKey, err := loadKeyFile(keyPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return open(masterKey, wrapped)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", keyAlg)
+	}
+}
+
+// writeHeader writes the magic and the header, returning the header bytes as written.
+func writeHeader(w io.Writer, header *Header) ([]byte, error) {
+	data, err := json.Marshal(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.WriteString(w, magic); err != nil {
+		return nil, err
+	}
+
+	if err = binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readHeader reads the magic and the header, returning the header bytes as read.
+func readHeader(r io.Reader) (*Header, []byte, error) {
+	prefix := make([]byte, len(magic))
+
+	if _, err := io.ReadFull(r, prefix); err != nil || string(prefix) != magic {
+		return nil, nil, errors.New("not an encrypted recording")
+	}
+
+	var length uint32
+
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, nil, err
+	}
+
+	if length > 64*1024 {
+		return nil, nil, errors.New("invalid header length")
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, err
+	}
+
+	var header Header
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, nil, err
+	}
+
+	if header.ChunkSize <= 0 || header.ChunkSize > 16*1024*1024 {
+		return nil, nil, errors.New("invalid chunk size")
+	}
+
+	return &header, data, nil
+}
+
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ index
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// chunkAAD is the SHA-256 of the header, followed by the chunk index and the final flag.
+func chunkAAD(headerSum []byte, index uint64, final bool) []byte {
+	aad := make([]byte, len(headerSum)+9)
+	copy(aad, headerSum)
+	binary.BigEndian.PutUint64(aad[len(headerSum):], index)
+	if final {
+		aad[len(aad)-1] = 1
+	}
+	return aad
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce which is prepended to the result.
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// loadKeyFile reads a 32 byte key stored raw, hex or base64 encoded.
+func loadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	return nil, errors.New("key file must contain a 32 byte key")
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PublicKey)
+
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,203 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keys are the key files of one wrapping method: what encrypts and what decrypts.
+type keys struct {
+	publicKeyFile string
+	keyFile       string
+	decryptKey    string
+}
+
+func keyFileKeys(t *testing.T) keys {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "recordings.key")
+
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return keys{keyFile: path, decryptKey: path}
+}
+
+func rsaKeys(t *testing.T) keys {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folder := t.TempDir()
+	publicPath := filepath.Join(folder, "public.pem")
+	privatePath := filepath.Join(folder, "private.pem")
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys{publicKeyFile: publicPath, decryptKey: privatePath}
+}
+
+func encrypt(t *testing.T, k keys, plain []byte) []byte {
+	t.Helper()
+
+	e, err := NewEncrypter(k.publicKeyFile, k.keyFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed bytes.Buffer
+
+	if _, err = e.encrypt(bytes.NewReader(plain), &sealed); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// bodyOffset returns where the chunks start in an encrypted file.
+func bodyOffset(sealed []byte) int {
+	return len(magic) + 4 + int(binary.BigEndian.Uint32(sealed[len(magic):]))
+}
+
+func TestRoundTrip(t *testing.T) {
+	wrappings := []struct {
+		name string
+		keys func(t *testing.T) keys
+	}{
+		{KeyAlgRSA, rsaKeys},
+		{KeyAlgKeyFile, keyFileKeys},
+	}
+
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"single byte", 1},
+		{"partial chunk", ChunkSize / 3},
+		{"exact chunk", ChunkSize},
+		{"exact multiple of the chunk size", 3 * ChunkSize},
+		{"partial last chunk", 2*ChunkSize + 17},
+	}
+
+	for _, wrapping := range wrappings {
+		k := wrapping.keys(t)
+
+		for _, size := range sizes {
+			t.Run(wrapping.name+"/"+size.name, func(t *testing.T) {
+				plain := make([]byte, size.size)
+
+				if _, err := rand.Read(plain); err != nil {
+					t.Fatal(err)
+				}
+
+				sealed := encrypt(t, k, plain)
+				var out bytes.Buffer
+
+				if err := Decrypt(bytes.NewReader(sealed), &out, k.decryptKey); err != nil {
+					t.Fatalf("Decrypt: %v", err)
+				}
+
+				if !bytes.Equal(out.Bytes(), plain) {
+					t.Errorf("decrypted %d bytes, which don't match the %d encrypted", out.Len(), len(plain))
+				}
+			})
+		}
+	}
+}
+
+func TestDecryptRejects(t *testing.T) {
+	k := keyFileKeys(t)
+	plain := bytes.Repeat([]byte("frame"), ChunkSize) // five full chunks
+	sealed := encrypt(t, k, plain)
+	body := bodyOffset(sealed)
+	sealedChunk := ChunkSize + 16 // GCM adds a 16 byte tag
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[body+sealedChunk+100] ^= 1
+
+	// a header that parses the same but isn't the one the chunks were sealed with
+	header := sealed[len(magic)+4 : body]
+	padded := append([]byte(`{"comment":"x",`), header[1:]...)
+	swapped := append([]byte(magic), binary.BigEndian.AppendUint32(nil, uint32(len(padded)))...)
+	swapped = append(append(swapped, padded...), sealed[body:]...)
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		key    string
+	}{
+		{"truncated at a chunk boundary", sealed[:body+2*sealedChunk], k.decryptKey},
+		{"truncated inside a chunk", sealed[:len(sealed)-10], k.decryptKey},
+		{"only the header", sealed[:body], k.decryptKey},
+		{"tampered chunk", tampered, k.decryptKey},
+		{"changed header", swapped, k.decryptKey},
+		{"wrong key", sealed, keyFileKeys(t).decryptKey},
+		{"wrong kind of key", sealed, rsaKeys(t).decryptKey},
+		{"not encrypted", plain, k.decryptKey},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Decrypt(bytes.NewReader(test.sealed), &bytes.Buffer{}, test.key)
+
+			if err == nil {
+				t.Error("Decrypt succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecryptWithWrongRSAKey(t *testing.T) {
+	sealed := encrypt(t, rsaKeys(t), []byte("audio samples"))
+
+	err := Decrypt(bytes.NewReader(sealed), &bytes.Buffer{}, rsaKeys(t).decryptKey)
+
+	if err == nil || !strings.Contains(err.Error(), "unwrapping data key") {
+		t.Errorf("Decrypt with another private key = %v, want an unwrapping error", err)
+	}
+}
+
+func TestNewEncrypterWithoutKeys(t *testing.T) {
+	e, err := NewEncrypter("", "")
+
+	if e != nil || err != nil {
+		t.Errorf("NewEncrypter without keys = %v, %v, want nil, nil", e, err)
+	}
+}
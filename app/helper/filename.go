@@ -0,0 +1,81 @@
+package helper
+
+import (
+	"fmt"
+	"path/filepath"
+	"pirecorder/apperror"
+	"regexp"
+	"strings"
+)
+
+// MaxNameLength is the longest recording name accepted, leaving room for collision
+// suffixes, extensions and sidecars such as .sync.json within common filesystem limits.
+const MaxNameLength = 100
+
+// names start with a letter or digit, so they can't be hidden files or refer to a parent folder
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateName checks a client supplied recording name, i.e. a file name without
+// its extension. Names are used as is inside the recordings folders, so anything
+// that could leave them or confuse other tools is rejected.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return apperror.InvalidRequest.SetMessage("Recording name must not be empty")
+	case len(name) > MaxNameLength:
+		return apperror.InvalidRequest.SetMessage(fmt.Sprintf("Recording name must be at most %d characters long", MaxNameLength))
+	case strings.ContainsAny(name, `/\`):
+		return apperror.InvalidRequest.SetMessage("Recording name must not contain path separators")
+	case strings.Contains(name, ".."):
+		return apperror.InvalidRequest.SetMessage("Recording name must not contain '..'")
+	case !namePattern.MatchString(name):
+		return apperror.InvalidRequest.SetMessage("Recording name may only contain letters, digits, '.', '_' and '-' and must start with a letter or digit")
+	}
+
+	return nil
+}
+
+// ValidateFilename checks a client supplied recording file name, which must be a
+// valid name followed by the .avi or .wav extension.
+func ValidateFilename(filename string) error {
+	ext := filepath.Ext(filename)
+
+	if ext != ".avi" && ext != ".wav" {
+		return apperror.InvalidRequest.SetMessage("Only .avi and .wav recordings are supported")
+	}
+
+	return ValidateName(strings.TrimSuffix(filename, ext))
+}
+
+// maxNameAttempts bounds how many suffixes UniqueName tries before giving up.
+const maxNameAttempts = 1000
+
+// UniqueName returns name, or name-1, name-2 and so on, whichever exists reports as
+// unused first. The name is shortened to make room for the suffix, so the result is
+// never longer than MaxNameLength. Errors from exists are returned as they are.
+func UniqueName(name string, exists func(name string) (bool, error)) (string, error) {
+	for i := 0; i < maxNameAttempts; i++ {
+		unique := name
+
+		if i > 0 {
+			suffix := fmt.Sprintf("-%d", i)
+
+			if len(unique)+len(suffix) > MaxNameLength {
+				unique = unique[:MaxNameLength-len(suffix)]
+			}
+			unique += suffix
+		}
+
+		taken, err := exists(unique)
+
+		if err != nil {
+			return "", err
+		}
+
+		if !taken {
+			return unique, nil
+		}
+	}
+
+	return "", apperror.InvalidRequest.SetMessage(fmt.Sprintf("No unused name left for %s, choose a different name", name))
+}
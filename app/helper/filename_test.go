@@ -0,0 +1,177 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"pirecorder/apperror"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"plain", "meeting", true},
+		{"allowed punctuation", "site-1_cam.front", true},
+		{"generated id", "01HZX3Q9M2A7", true},
+		{"longest allowed", strings.Repeat("a", MaxNameLength), true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", MaxNameLength+1), false},
+		{"parent traversal", "../../etc/passwd", false},
+		{"dot dot inside", "a..b", false},
+		{"leading dot", ".hidden", false},
+		{"leading dash", "-rf", false},
+		{"slash", "videos/meeting", false},
+		{"backslash", `videos\meeting`, false},
+		{"windows traversal", `..\..\boot.ini`, false},
+		{"space", "my meeting", false},
+		{"unicode", "réunion", false},
+		{"null byte", "meeting\x00", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateName(test.input)
+
+			if test.valid && err != nil {
+				t.Errorf("ValidateName(%q) = %v, want nil", test.input, err)
+			}
+
+			if !test.valid && !errors.Is(err, apperror.InvalidRequest) {
+				t.Errorf("ValidateName(%q) = %v, want an invalid request", test.input, err)
+			}
+		})
+	}
+}
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"video", "meeting.avi", true},
+		{"audio", "meeting.wav", true},
+		{"name with dots", "2024.05.01.wav", true},
+		{"wrong extension", "meeting.mp4", false},
+		{"upper case extension", "meeting.AVI", false},
+		{"no extension", "meeting", false},
+		{"extension only", ".avi", false},
+		{"parent traversal", "../../etc/passwd", false},
+		{"traversal with extension", "../meeting.avi", false},
+		{"backslash", `videos\meeting.avi`, false},
+		{"leading dot", ".meeting.wav", false},
+		{"too long", strings.Repeat("a", MaxNameLength+1) + ".avi", false},
+		{"longest allowed", strings.Repeat("a", MaxNameLength) + ".avi", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateFilename(test.input)
+
+			if test.valid && err != nil {
+				t.Errorf("ValidateFilename(%q) = %v, want nil", test.input, err)
+			}
+
+			if !test.valid && !errors.Is(err, apperror.InvalidRequest) {
+				t.Errorf("ValidateFilename(%q) = %v, want an invalid request", test.input, err)
+			}
+		})
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	long := strings.Repeat("a", MaxNameLength)
+
+	tests := []struct {
+		name  string
+		input string
+		taken []string
+		want  string
+	}{
+		{"unused", "meeting", nil, "meeting"},
+		{"first suffix", "meeting", []string{"meeting"}, "meeting-1"},
+		{"next free suffix", "meeting", []string{"meeting", "meeting-1", "meeting-2"}, "meeting-3"},
+		{"gap in suffixes", "meeting", []string{"meeting", "meeting-2"}, "meeting-1"},
+		{"shortened for the suffix", long, []string{long}, long[:MaxNameLength-2] + "-1"},
+		{"shortened more for longer suffixes", long, suffixed(long, 10), long[:MaxNameLength-3] + "-10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, name := range test.taken {
+				taken[name] = true
+			}
+
+			got, err := UniqueName(test.input, func(name string) (bool, error) {
+				return taken[name], nil
+			})
+
+			if err != nil {
+				t.Fatalf("UniqueName(%q) = %v", test.input, err)
+			}
+
+			if got != test.want {
+				t.Errorf("UniqueName(%q) = %q, want %q", test.input, got, test.want)
+			}
+
+			if len(got) > MaxNameLength {
+				t.Errorf("UniqueName(%q) is %d characters long, more than %d", test.input, len(got), MaxNameLength)
+			}
+
+			if err = ValidateName(got); err != nil {
+				t.Errorf("UniqueName(%q) = %q, which is not a valid name: %v", test.input, got, err)
+			}
+		})
+	}
+}
+
+func TestUniqueNameGivesUp(t *testing.T) {
+	calls := 0
+
+	_, err := UniqueName("meeting", func(string) (bool, error) {
+		calls++
+		return true, nil
+	})
+
+	if !errors.Is(err, apperror.InvalidRequest) {
+		t.Errorf("UniqueName with every name taken = %v, want an invalid request", err)
+	}
+
+	if calls != maxNameAttempts {
+		t.Errorf("UniqueName tried %d names, want %d", calls, maxNameAttempts)
+	}
+}
+
+func TestUniqueNameReturnsLookupErrors(t *testing.T) {
+	failed := errors.New("permission denied")
+	calls := 0
+
+	_, err := UniqueName("meeting", func(string) (bool, error) {
+		calls++
+		return false, failed
+	})
+
+	if !errors.Is(err, failed) {
+		t.Errorf("UniqueName = %v, want %v", err, failed)
+	}
+
+	if calls != 1 {
+		t.Errorf("UniqueName tried %d names after an error, want 1", calls)
+	}
+}
+
+// suffixed returns name and name-1 to name-(n-1), shortened like UniqueName does.
+func suffixed(name string, n int) []string {
+	names := []string{name}
+
+	for i := 1; i < n; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		names = append(names, name[:MaxNameLength-len(suffix)]+suffix)
+	}
+	return names
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"pirecorder/apperror"
 	"pirecorder/config"
+	"time"
 )
 
 func FetchFiles() ([]string, error) {
@@ -73,3 +74,17 @@ func Truncate(num float64, unit float64) float64 {
 
 	return f
 }
+
+// Backoff doubles base for every attempt after the first, up to max.
+func Backoff(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		return max
+	}
+	return delay
+}
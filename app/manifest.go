@@ -0,0 +1,55 @@
+package app
+
+import (
+	"pirecorder/models"
+	"time"
+)
+
+// saveManifests keeps the capture details of the recordings that just stopped
+// for the manifests uploaded with them.
+func (a *App) saveManifests(videoFile, audioFile string) {
+	if a.uploader == nil {
+		return
+	}
+
+	stoppedAt := time.Now().UTC()
+	recording, _ := a.catalog.Get(a.recordingID)
+
+	if videoFile != "" {
+		video := a.camera.RecordingInfo()
+		a.saveManifest(models.RecordingManifest{
+			RecordingID: recording.ID,
+			Label:       recording.Label,
+			Tags:        recording.Tags,
+			Filename:    videoFile,
+			Kind:        "videos",
+			StartedAt:   a.recordingStart.UTC(),
+			StoppedAt:   stoppedAt,
+			Video:       &video,
+			Request:     a.recordingMeta,
+		})
+	}
+
+	if audioFile != "" {
+		audio := a.mic.RecordingInfo()
+		a.saveManifest(models.RecordingManifest{
+			RecordingID: recording.ID,
+			Label:       recording.Label,
+			Tags:        recording.Tags,
+			Filename:    audioFile,
+			Kind:        "audios",
+			StartedAt:   a.recordingStart.UTC(),
+			StoppedAt:   stoppedAt,
+			Audio:       &audio,
+			Request:     a.recordingMeta,
+		})
+	}
+
+	a.recordingMeta = nil
+}
+
+func (a *App) saveManifest(manifest models.RecordingManifest) {
+	if err := a.uploader.SaveManifest(manifest); err != nil {
+		a.logger.LogError(err, "Error saving recording manifest", "filename", manifest.Filename)
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pirecorder/app/catalog"
+	"pirecorder/app/helper"
+	"pirecorder/app/upload"
+	"pirecorder/config"
+	"pirecorder/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reconcile brings the catalog in line with the recordings folders when the app
+// starts: files that belong to no recording are adopted, files of recordings that
+// vanished without being deleted are marked missing and recordings interrupted by
+// a restart are marked stopped.
+func (a *App) reconcile() error {
+	files, err := helper.FetchFiles()
+
+	if err != nil {
+		return err
+	}
+
+	onDisk := make(map[string]bool)
+	for _, file := range files {
+		if ext := filepath.Ext(file); ext == ".avi" || ext == ".wav" {
+			onDisk[file] = true
+		}
+	}
+
+	recordings, err := a.catalog.List()
+
+	if err != nil {
+		return err
+	}
+
+	for _, recording := range recordings {
+		err = a.catalog.Update(recording.ID, func(recording *models.Recording) {
+			var stoppedAt time.Time
+
+			for i := range recording.Files {
+				file := &recording.Files[i]
+
+				if !onDisk[file.Filename] {
+					file.Missing = file.DeletedAt == nil
+					continue
+				}
+
+				delete(onDisk, file.Filename)
+				file.Missing = false
+				refreshFile(file)
+
+				if _, stop, _, err := upload.Inspect(file.Filename); err == nil && stop.After(stoppedAt) {
+					stoppedAt = stop
+				}
+			}
+
+			if recording.Status == catalog.StatusRecording {
+				if stoppedAt.IsZero() {
+					stoppedAt = recording.StartedAt
+				}
+				stoppedAt = stoppedAt.UTC()
+				recording.Status = catalog.StatusStopped
+				recording.StoppedAt = &stoppedAt
+				recording.DurationSeconds = stoppedAt.Sub(recording.StartedAt).Seconds()
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// whatever is left belongs to no recording, files sharing a name are recorded together
+	orphans := make(map[string][]string)
+	for file := range onDisk {
+		name := strings.TrimSuffix(file, filepath.Ext(file))
+		orphans[name] = append(orphans[name], file)
+	}
+
+	for _, files := range orphans {
+		if _, err = a.adopt(files); err != nil {
+			return err
+		}
+	}
+
+	if len(orphans) > 0 {
+		a.logger.LogInfo("Adopted recordings found on disk", "count", fmt.Sprint(len(orphans)))
+	}
+
+	return nil
+}
+
+// adopt adds a recording for files that weren't started through the API.
+func (a *App) adopt(files []string) (models.Recording, error) {
+	id, err := catalog.NewID()
+
+	if err != nil {
+		return models.Recording{}, err
+	}
+
+	sort.Strings(files)
+
+	recording := models.Recording{
+		ID:      id,
+		Mode:    modeAudioOnly,
+		Status:  catalog.StatusStopped,
+		Adopted: true,
+	}
+
+	var startedAt, stoppedAt time.Time
+
+	for _, filename := range files {
+		file := models.RecordingFile{Filename: filename, Kind: "audios"}
+
+		if filepath.Ext(filename) == ".avi" {
+			file.Kind = "videos"
+			recording.Mode = modeAudioVideo
+		}
+
+		refreshFile(&file)
+
+		if a.uploader != nil {
+			if state := a.uploader.UploadState(filename); state != nil && state.UploadedAt != nil {
+				uploadedAt := state.UploadedAt.UTC()
+				file.Key = state.Key
+				file.UploadedAt = &uploadedAt
+			}
+		}
+
+		recording.Files = append(recording.Files, file)
+
+		start, stop, _, err := upload.Inspect(filename)
+
+		if err != nil {
+			continue
+		}
+
+		if startedAt.IsZero() || start.Before(startedAt) {
+			startedAt = start
+		}
+
+		if stop.After(stoppedAt) {
+			stoppedAt = stop
+		}
+	}
+
+	recording.StartedAt = startedAt.UTC()
+	stoppedAt = stoppedAt.UTC()
+	recording.StoppedAt = &stoppedAt
+	recording.DurationSeconds = stoppedAt.Sub(startedAt).Seconds()
+
+	return recording, a.catalog.Add(recording)
+}
+
+// clipFinished adopts a voice activated clip and queues it for upload.
+func (a *App) clipFinished(filename string) {
+	if _, err := a.adopt([]string{filename}); err != nil {
+		a.logger.LogError(err, "Error saving recording", "filename", filename)
+	}
+
+	if a.uploader != nil && config.GetConfig().StoreConfig.AutoUpload {
+		a.uploader.Enqueue(filename)
+	}
+}
+
+// refreshFile reads the size and duration of a recording file from disk.
+func refreshFile(file *models.RecordingFile) {
+	if stat, err := os.Stat(localPath(*file)); err == nil {
+		file.Size = stat.Size()
+	}
+
+	if _, _, duration, err := upload.Inspect(file.Filename); err == nil {
+		file.DurationSeconds = duration.Seconds()
+	}
+}
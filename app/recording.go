@@ -0,0 +1,498 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/app/catalog"
+	"pirecorder/app/helper"
+	"pirecorder/apperror"
+	"pirecorder/config"
+	"pirecorder/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordingFiles returns the files the recorders that just started are writing.
+func (a *App) recordingFiles(filename string) []models.RecordingFile {
+	var files []models.RecordingFile
+
+	if recording, name := a.camRecordingStats(); recording && name == filename+".avi" {
+		files = append(files, models.RecordingFile{Filename: name, Kind: "videos"})
+	}
+
+	if recording, name := a.mic.RecordingStats(); recording && name == filename+".wav" {
+		files = append(files, models.RecordingFile{Filename: name, Kind: "audios"})
+	}
+
+	return files
+}
+
+// finishRecording marks the recording in progress as stopped.
+func (a *App) finishRecording() {
+	if a.recordingID == "" {
+		return
+	}
+
+	id := a.recordingID
+	a.recordingID = ""
+
+	err := a.catalog.Update(id, func(recording *models.Recording) {
+		stoppedAt := time.Now().UTC()
+		recording.Status = catalog.StatusStopped
+		recording.StoppedAt = &stoppedAt
+		recording.DurationSeconds = stoppedAt.Sub(recording.StartedAt).Seconds()
+
+		// the headers are only complete once the recorders close the files, see reconcile
+		for i := range recording.Files {
+			recording.Files[i].DurationSeconds = recording.DurationSeconds
+		}
+	})
+
+	if err != nil {
+		a.logger.LogError(err, "Error saving recording", "id", id)
+	}
+}
+
+// fileUploaded records the upload of a recording file, it is called by the uploader.
+func (a *App) fileUploaded(filename, key string, deleted bool) {
+	err := a.catalog.UpdateFile(filename, func(file *models.RecordingFile) {
+		now := time.Now().UTC()
+		file.Key = key
+		file.UploadedAt = &now
+		if deleted {
+			file.DeletedAt = &now
+		}
+	})
+
+	if err != nil {
+		a.logger.LogError(err, "Error saving recording", "filename", filename)
+	}
+}
+
+// fileDeleted records that the local copy of a recording file is gone, it is
+// called by the retention manager.
+func (a *App) fileDeleted(filename string) {
+	if a.uploader != nil {
+		a.uploader.Forget(filename)
+	}
+
+	err := a.catalog.UpdateFile(filename, func(file *models.RecordingFile) {
+		now := time.Now().UTC()
+		file.DeletedAt = &now
+	})
+
+	if err != nil {
+		a.logger.LogError(err, "Error saving recording", "filename", filename)
+	}
+}
+
+// pruneCatalog drops recordings whose files were all deleted without being uploaded
+// once they have been gone for KeepDeletedFor, so the catalog doesn't grow forever.
+// Uploaded recordings are kept, they are what leads to the objects in the store.
+func (a *App) pruneCatalog() {
+	conf := config.GetConfig().Retention
+
+	if conf.KeepDeletedFor <= 0 {
+		return
+	}
+
+	for {
+		cutoff := time.Now().Add(-conf.KeepDeletedFor)
+		removed, err := a.catalog.Prune(func(recording models.Recording) bool {
+			return deletedBefore(recording, cutoff)
+		})
+
+		if err != nil {
+			a.logger.LogError(err, "Error pruning recordings catalog")
+		} else if removed > 0 {
+			a.logger.LogInfo("Pruned deleted recordings from the catalog", "count", fmt.Sprint(removed))
+		}
+
+		if conf.Interval <= 0 {
+			return
+		}
+		time.Sleep(conf.Interval)
+	}
+}
+
+// deletedBefore reports whether none of the files of a recording is on disk or
+// uploaded, and the last of them was gone before cutoff.
+func deletedBefore(recording models.Recording, cutoff time.Time) bool {
+	if recording.Status == catalog.StatusRecording {
+		return false
+	}
+
+	gone := recording.StartedAt
+	if recording.StoppedAt != nil {
+		gone = *recording.StoppedAt
+	}
+
+	for _, file := range recording.Files {
+		switch {
+		case file.UploadedAt != nil:
+			return false
+		case file.DeletedAt != nil:
+			if file.DeletedAt.After(gone) {
+				gone = *file.DeletedAt
+			}
+		case !file.Missing:
+			return false
+		}
+	}
+
+	return gone.Before(cutoff)
+}
+
+// recordingDetails adds the live state of the files on disk to a recording, uploads
+// are the uploads in progress as returned by uploadsByFile.
+func (a *App) recordingDetails(recording models.Recording, uploads map[string]models.UploadProgress) *models.Recording {
+	uploaded, local, uploading := 0, 0, false
+
+	for i := range recording.Files {
+		file := &recording.Files[i]
+
+		if file.UploadedAt != nil {
+			uploaded++
+		}
+
+		stat, err := os.Stat(localPath(*file))
+
+		if err != nil {
+			continue
+		}
+
+		file.Size = stat.Size()
+		details := a.fileDetails(file.Filename, uploads)
+		file.Local = &details
+		uploading = uploading || details.Uploading
+		local++
+	}
+
+	switch {
+	case recording.Status == catalog.StatusRecording:
+	case uploading:
+		recording.Status = catalog.StatusUploading
+	case len(recording.Files) > 0 && uploaded == len(recording.Files):
+		recording.Status = catalog.StatusUploaded
+	case local == 0:
+		recording.Status = catalog.StatusDeleted
+	}
+
+	return &recording
+}
+
+// ListRecordings returns the recordings matching filter in the order it asks for.
+func (a *App) ListRecordings(filter models.RecordingFilter) ([]models.Recording, error) {
+	recordings, err := a.catalog.List()
+
+	if err != nil {
+		a.logger.LogError(err, "Error listing recordings")
+		return nil, apperror.ServerError
+	}
+
+	uploads := a.uploadsByFile()
+	matching := make([]models.Recording, 0, len(recordings))
+
+	for _, recording := range recordings {
+		// only the status depends on the files on disk, the rest is checked without touching them
+		if !matches(&recording, filter) {
+			continue
+		}
+
+		details := a.recordingDetails(recording, uploads)
+
+		if filter.Status == "" || details.Status == filter.Status {
+			matching = append(matching, *details)
+		}
+	}
+
+	sortRecordings(matching, filter)
+
+	if filter.Offset >= len(matching) {
+		return []models.Recording{}, nil
+	}
+
+	matching = matching[filter.Offset:]
+
+	if filter.Limit > 0 && filter.Limit < len(matching) {
+		matching = matching[:filter.Limit]
+	}
+
+	return matching, nil
+}
+
+// matches checks the stored fields of a recording against filter, everything but the status.
+func matches(recording *models.Recording, filter models.RecordingFilter) bool {
+	if filter.Mode != "" && recording.Mode != filter.Mode {
+		return false
+	}
+
+	if filter.Label != "" && !strings.Contains(strings.ToLower(recording.Label), strings.ToLower(filter.Label)) {
+		return false
+	}
+
+	if !filter.From.IsZero() && recording.StartedAt.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && recording.StartedAt.After(filter.To) {
+		return false
+	}
+
+	if filter.Tag == "" {
+		return true
+	}
+
+	for _, tag := range recording.Tags {
+		if tag == filter.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortRecordings(recordings []models.Recording, filter models.RecordingFilter) {
+	less := func(a, b *models.Recording) bool { return a.StartedAt.Before(b.StartedAt) }
+
+	switch filter.Sort {
+	case "duration":
+		less = func(a, b *models.Recording) bool { return a.DurationSeconds < b.DurationSeconds }
+	case "size":
+		less = func(a, b *models.Recording) bool { return recordingSize(a) < recordingSize(b) }
+	case "label":
+		less = func(a, b *models.Recording) bool { return strings.ToLower(a.Label) < strings.ToLower(b.Label) }
+	}
+
+	sort.SliceStable(recordings, func(i, j int) bool {
+		if filter.Desc {
+			return less(&recordings[j], &recordings[i])
+		}
+		return less(&recordings[i], &recordings[j])
+	})
+}
+
+func recordingSize(recording *models.Recording) int64 {
+	var size int64
+	for _, file := range recording.Files {
+		size += file.Size
+	}
+	return size
+}
+
+func (a *App) GetRecording(id string) (*models.Recording, error) {
+	recording, ok := a.catalog.Get(id)
+
+	if !ok {
+		return nil, apperror.NotFound.SetMessage("Recording not found")
+	}
+
+	return a.recordingDetails(recording, a.uploadsByFile()), nil
+}
+
+// UploadRecordingByID uploads every file of a recording that is on disk and not uploaded yet.
+// The returned DeferredUpload is set when files were queued for the next upload window instead.
+func (a *App) UploadRecordingByID(id string, ignoreWindow bool) (*models.Recording, *models.DeferredUpload, error) {
+	recording, err := a.GetRecording(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if recording.Status == catalog.StatusRecording {
+		return nil, nil, apperror.ServiceUnavailable.SetMessage("Cannot upload recording while recording is in progress")
+	}
+
+	if a.uploader == nil {
+		return nil, nil, errUploaderDown
+	}
+
+	var (
+		deferred  *models.DeferredUpload
+		uploadErr error
+	)
+
+	for _, file := range recording.Files {
+		if file.Local == nil || a.uploader.IsUploaded(file.Filename) {
+			continue
+		}
+
+		// keep going so a failing file doesn't hold up the others
+		queued, err := a.uploader.UploadRecording(file.Filename, ignoreWindow)
+
+		if err != nil && uploadErr == nil {
+			uploadErr = err
+		}
+
+		if queued != nil {
+			if deferred == nil {
+				deferred = queued
+			} else {
+				deferred.Queued = append(deferred.Queued, queued.Queued...)
+			}
+		}
+	}
+
+	if uploadErr != nil {
+		return nil, nil, uploadErr
+	}
+
+	recording, err = a.GetRecording(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if deferred != nil {
+		deferred.Recording = recording
+	}
+
+	return recording, deferred, nil
+}
+
+// DeleteRecording deletes the local files of a recording, the recording itself
+// stays in the catalog as deleted.
+func (a *App) DeleteRecording(id string) (*models.Recording, error) {
+	recording, err := a.GetRecording(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range recording.Files {
+		if recording.Status == catalog.StatusRecording || a.isBusy(file.Filename) {
+			return nil, apperror.ServiceUnavailable.SetMessage("Cannot delete a recording that is being recorded or uploaded")
+		}
+	}
+
+	for _, file := range recording.Files {
+		if file.Local == nil {
+			continue
+		}
+
+		path := localPath(file)
+
+		if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			a.logger.LogError(err, "Error deleting recording file", "id", id, "filename", file.Filename)
+			return nil, apperror.ServerError
+		}
+
+		if file.Kind == "videos" {
+			_ = os.Remove(strings.TrimSuffix(path, ".avi") + ".sync.json")
+		}
+
+		a.fileDeleted(file.Filename)
+		a.logger.LogInfo("Deleted recording file", "id", id, "filename", file.Filename)
+	}
+
+	return a.GetRecording(id)
+}
+
+// nameTaken reports whether a recording name is used by files on disk or in the catalog.
+// Files that can't be checked are an error rather than taken, as retrying won't help.
+func (a *App) nameTaken(name string) (bool, error) {
+	for _, file := range []models.RecordingFile{{Filename: name + ".avi", Kind: "videos"}, {Filename: name + ".wav", Kind: "audios"}} {
+		_, err := os.Stat(localPath(file))
+
+		if err == nil {
+			return true, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+
+		if _, ok := a.catalog.ByFile(file.Filename); ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func localPath(file models.RecordingFile) string {
+	folder := config.GetConfig().AudiosFolder
+	if file.Kind == "videos" {
+		folder = config.GetConfig().VideosFolder
+	}
+	return fmt.Sprintf("%s/%s", folder, file.Filename)
+}
+
+// OpenRecording opens a local recording file for download, files that are still
+// being written are refused.
+func (a *App) OpenRecording(filename string) (*os.File, os.FileInfo, error) {
+	if err := helper.ValidateFilename(filename); err != nil {
+		return nil, nil, err
+	}
+
+	if a.isWriting(filename) {
+		return nil, nil, apperror.ServiceUnavailable.SetMessage("Cannot download a recording that is still being written")
+	}
+
+	file := models.RecordingFile{Filename: filename, Kind: "audios"}
+	if filepath.Ext(filename) == ".avi" {
+		file.Kind = "videos"
+	}
+
+	f, err := os.Open(localPath(file))
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, apperror.NotFound.SetMessage("Recording not found")
+		}
+		a.logger.LogError(err, "Error opening recording", "filename", filename)
+		return nil, nil, apperror.ServerError
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		_ = f.Close()
+		a.logger.LogError(err, "Error reading recording", "filename", filename)
+		return nil, nil, apperror.ServerError
+	}
+
+	return f, info, nil
+}
+
+// OpenRecordingByID opens the video or audio file of a recording for download,
+// kind is videos or audios and defaults to the video if there is one.
+func (a *App) OpenRecordingByID(id, kind string) (*os.File, os.FileInfo, error) {
+	recording, ok := a.catalog.Get(id)
+
+	if !ok {
+		return nil, nil, apperror.NotFound.SetMessage("Recording not found")
+	}
+
+	if kind != "" && kind != "videos" && kind != "audios" {
+		return nil, nil, apperror.InvalidRequest.SetMessage("kind must be videos or audios")
+	}
+
+	var filename string
+
+	for _, file := range recording.Files {
+		if file.Kind == kind || (kind == "" && (filename == "" || file.Kind == "videos")) {
+			filename = file.Filename
+		}
+	}
+
+	if filename == "" {
+		return nil, nil, apperror.NotFound.SetMessage("Recording has no such file")
+	}
+
+	return a.OpenRecording(filename)
+}
+
+// isWriting reports whether a recorder is still writing to a file.
+func (a *App) isWriting(filename string) bool {
+	if recording, name := a.camRecordingStats(); recording && name == filename {
+		return true
+	}
+
+	recording, name := a.mic.RecordingStats()
+	return recording && name == filename
+}
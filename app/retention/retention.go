@@ -0,0 +1,280 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	reasonMaxAge   = "max-age"
+	reasonMaxBytes = "max-bytes"
+	reasonMinFree  = "min-free-space"
+	reasonKeepTime = "keep-expired"
+)
+
+type recording struct {
+	path     string
+	filename string
+	size     int64
+	modTime  time.Time
+	uploaded bool
+	// uploadedAt is set for recordings that are uploaded
+	uploadedAt time.Time
+}
+
+// Manager deletes local recordings to keep within the configured retention limits.
+// Recordings that have been uploaded are deleted oldest first, ones that haven't
+// only when the force policy is set and the limits can't be met otherwise.
+type Manager struct {
+	conf       config.Retention
+	busy       func(filename string) bool
+	uploadedAt func(filename string) (time.Time, bool)
+	trigger    chan struct{}
+	onDelete   func(filename string)
+	logger     *logger.Logger
+}
+
+// NewManager creates a manager. busy reports recordings that must not be touched
+// because they are being recorded or uploaded, uploadedAt when a recording was uploaded.
+func NewManager(logger *logger.Logger, conf config.Retention, busy func(string) bool, uploadedAt func(string) (time.Time, bool)) *Manager {
+	return &Manager{
+		conf:       conf,
+		busy:       busy,
+		uploadedAt: uploadedAt,
+		trigger:    make(chan struct{}, 1),
+		logger:     logger,
+	}
+}
+
+func (m *Manager) enabled() bool {
+	return m.conf.MaxAge > 0 || m.conf.MaxBytes > 0 || m.conf.MinFreePercent > 0 || m.conf.KeepUploadedFor > 0
+}
+
+// OnDelete registers a function called with the file name of every deleted recording.
+func (m *Manager) OnDelete(handler func(filename string)) {
+	m.onDelete = handler
+}
+
+// Run enforces the limits every interval and whenever Trigger is called.
+func (m *Manager) Run() {
+	if !m.enabled() {
+		return
+	}
+
+	for {
+		m.Enforce()
+
+		select {
+		case <-m.trigger:
+		case <-time.After(m.conf.Interval):
+		}
+	}
+}
+
+// Trigger asks for the limits to be checked, e.g. after a recording finished.
+func (m *Manager) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Enforce deletes recordings until every limit is met or nothing more may be deleted.
+func (m *Manager) Enforce() {
+	recordings, err := m.list()
+
+	if err != nil {
+		m.logger.LogError(err, "Error listing recordings for retention")
+		return
+	}
+
+	var total int64
+	for _, rec := range recordings {
+		total += rec.size
+	}
+
+	// uploaded recordings go first, each group oldest first
+	sort.SliceStable(recordings, func(i, j int) bool {
+		if recordings[i].uploaded != recordings[j].uploaded {
+			return recordings[i].uploaded
+		}
+		return recordings[i].modTime.Before(recordings[j].modTime)
+	})
+
+	remaining := recordings[:0]
+
+	for _, rec := range recordings {
+		if m.conf.MaxAge > 0 && time.Since(rec.modTime) > m.conf.MaxAge && m.allowed(rec) {
+			if m.delete(rec, reasonMaxAge) {
+				total -= rec.size
+				continue
+			}
+		}
+
+		if m.conf.KeepUploadedFor > 0 && rec.uploaded && time.Since(rec.uploadedAt) > m.conf.KeepUploadedFor && m.allowed(rec) {
+			if m.delete(rec, reasonKeepTime) {
+				total -= rec.size
+				continue
+			}
+		}
+		remaining = append(remaining, rec)
+	}
+
+	for _, rec := range remaining {
+		overSize := m.conf.MaxBytes > 0 && total > m.conf.MaxBytes
+		lowSpace := m.conf.MinFreePercent > 0 && m.freePercent() < m.conf.MinFreePercent
+
+		if !overSize && !lowSpace {
+			return
+		}
+
+		reason := reasonMaxBytes
+		if !overSize {
+			reason = reasonMinFree
+		}
+
+		if !m.allowed(rec) {
+			continue
+		}
+
+		if m.delete(rec, reason) {
+			total -= rec.size
+		}
+	}
+
+	if m.conf.MaxBytes > 0 && total > m.conf.MaxBytes {
+		m.logger.LogWarning(errors.New("retention limit exceeded"), "Recordings exceed the size limit and nothing more may be deleted",
+			"total_bytes", fmt.Sprint(total), "max_bytes", fmt.Sprint(m.conf.MaxBytes))
+	}
+
+	if free := m.freePercent(); m.conf.MinFreePercent > 0 && free < m.conf.MinFreePercent {
+		m.logger.LogWarning(errors.New("low disk space"), "Free disk space is below the limit and nothing more may be deleted",
+			"free_percent", fmt.Sprintf("%.1f", free), "min_free_percent", fmt.Sprintf("%.1f", m.conf.MinFreePercent))
+	}
+}
+
+// allowed reports whether a recording may be deleted at all.
+func (m *Manager) allowed(rec recording) bool {
+	if m.busy(rec.filename) {
+		return false
+	}
+
+	return rec.uploaded || m.conf.Force
+}
+
+func (m *Manager) delete(rec recording, reason string) bool {
+	if err := os.Remove(rec.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		m.logger.LogError(err, "Error deleting recording", "file_name", rec.filename, "reason", reason)
+		return false
+	}
+
+	// sidecars such as <name>.sync.json go with the video
+	if filepath.Ext(rec.filename) == ".avi" {
+		_ = os.Remove(strings.TrimSuffix(rec.path, ".avi") + ".sync.json")
+	}
+
+	m.logger.LogInfo("Deleted recording", "file_name", rec.filename, "reason", reason,
+		"uploaded", fmt.Sprint(rec.uploaded), "size", fmt.Sprint(rec.size), "modified", rec.modTime.Format(time.RFC3339))
+
+	if m.onDelete != nil {
+		m.onDelete(rec.filename)
+	}
+	return true
+}
+
+func (m *Manager) list() ([]recording, error) {
+	var recordings []recording
+
+	for _, folder := range recordingFolders() {
+		entries, err := os.ReadDir(folder)
+
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+
+			if entry.IsDir() || (ext != ".avi" && ext != ".wav") {
+				continue
+			}
+
+			info, err := entry.Info()
+
+			if err != nil {
+				continue
+			}
+
+			uploadedAt, uploaded := m.uploadedAt(entry.Name())
+			recordings = append(recordings, recording{
+				path:       filepath.Join(folder, entry.Name()),
+				filename:   entry.Name(),
+				size:       info.Size(),
+				modTime:    info.ModTime(),
+				uploaded:   uploaded,
+				uploadedAt: uploadedAt,
+			})
+		}
+	}
+
+	return recordings, nil
+}
+
+// freePercent returns the free space where recordings are written, 100 when it can't
+// be measured so nothing is deleted because of it.
+func (m *Manager) freePercent() float64 {
+	free, err := FreePercent()
+
+	if err != nil {
+		m.logger.LogError(err, "Error measuring free disk space for retention")
+		return 100
+	}
+	return free
+}
+
+func recordingFolders() []string {
+	return []string{config.GetConfig().VideosFolder, config.GetConfig().AudiosFolder}
+}
+
+// FreePercent returns the free space, in percent, on the fullest filesystem holding
+// a recordings folder. Folders that don't exist, e.g. videos in audio-only setups, are skipped.
+func FreePercent() (float64, error) {
+	free := -1.0
+
+	for _, folder := range recordingFolders() {
+		var stat unix.Statfs_t
+
+		if err := unix.Statfs(folder, &stat); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return 0, fmt.Errorf("statfs %s: %w", folder, err)
+		}
+
+		if stat.Blocks == 0 {
+			continue
+		}
+
+		if percent := float64(stat.Bavail) / float64(stat.Blocks) * 100; free < 0 || percent < free {
+			free = percent
+		}
+	}
+
+	if free < 0 {
+		return 0, errors.New("no recordings folder to measure")
+	}
+	return free, nil
+}
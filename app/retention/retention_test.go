@@ -0,0 +1,163 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testFile is a recording on disk, age old and uploaded if set.
+type testFile struct {
+	name     string
+	age      time.Duration
+	uploaded bool
+}
+
+// setup writes 100 byte recordings into fresh recording folders and returns a manager
+// for them, along with the list its deletions are recorded in.
+func setup(t *testing.T, conf config.Retention, files []testFile, busy ...string) (*Manager, *[]string) {
+	t.Helper()
+
+	previous := config.Conf
+	config.Conf.VideosFolder = t.TempDir()
+	config.Conf.AudiosFolder = t.TempDir()
+	t.Cleanup(func() { config.Conf = previous })
+
+	uploaded := make(map[string]bool)
+
+	for _, file := range files {
+		folder := config.Conf.VideosFolder
+		if filepath.Ext(file.name) == ".wav" {
+			folder = config.Conf.AudiosFolder
+		}
+
+		path := filepath.Join(folder, file.name)
+
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		modified := time.Now().Add(-file.age)
+
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+
+		uploaded[file.name] = file.uploaded
+	}
+
+	isBusy := func(filename string) bool {
+		for _, name := range busy {
+			if name == filename {
+				return true
+			}
+		}
+		return false
+	}
+
+	uploadedAt := func(filename string) (time.Time, bool) {
+		return time.Now().Add(-time.Hour), uploaded[filename]
+	}
+
+	m := NewManager(logger.NewDiscardLogger(), conf, isBusy, uploadedAt)
+	deleted := &[]string{}
+	m.OnDelete(func(filename string) { *deleted = append(*deleted, filename) })
+
+	return m, deleted
+}
+
+var files = []testFile{
+	{"new-uploaded.avi", 1 * time.Hour, true},
+	{"oldest.wav", 5 * time.Hour, false},
+	{"old-uploaded.wav", 3 * time.Hour, true},
+	{"old.avi", 4 * time.Hour, false},
+}
+
+func TestEnforceDeletionOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     config.Retention
+		busy     []string
+		expected []string
+	}{
+		{
+			name:     "uploaded only without force",
+			conf:     config.Retention{MaxBytes: 100},
+			expected: []string{"old-uploaded.wav", "new-uploaded.avi"},
+		},
+		{
+			name:     "uploaded first, then oldest first with force",
+			conf:     config.Retention{MaxBytes: 100, Force: true},
+			expected: []string{"old-uploaded.wav", "new-uploaded.avi", "oldest.wav"},
+		},
+		{
+			name:     "stops once within the limit",
+			conf:     config.Retention{MaxBytes: 300, Force: true},
+			expected: []string{"old-uploaded.wav"},
+		},
+		{
+			name:     "busy files are skipped",
+			conf:     config.Retention{MaxBytes: 100, Force: true},
+			busy:     []string{"old-uploaded.wav", "oldest.wav"},
+			expected: []string{"new-uploaded.avi", "old.avi"},
+		},
+		{
+			name:     "max age keeps recordings that aren't uploaded",
+			conf:     config.Retention{MaxAge: 2 * time.Hour},
+			expected: []string{"old-uploaded.wav"},
+		},
+		{
+			name:     "max age with force",
+			conf:     config.Retention{MaxAge: 2 * time.Hour, Force: true},
+			expected: []string{"old-uploaded.wav", "oldest.wav", "old.avi"},
+		},
+		{
+			name:     "kept for a while after the upload",
+			conf:     config.Retention{KeepUploadedFor: 30 * time.Minute},
+			expected: []string{"old-uploaded.wav", "new-uploaded.avi"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, deleted := setup(t, test.conf, files, test.busy...)
+			m.Enforce()
+
+			if !reflect.DeepEqual(*deleted, test.expected) {
+				t.Errorf("deleted %v, want %v", *deleted, test.expected)
+			}
+
+			for _, name := range *deleted {
+				folder := config.Conf.VideosFolder
+				if filepath.Ext(name) == ".wav" {
+					folder = config.Conf.AudiosFolder
+				}
+
+				if _, err := os.Stat(filepath.Join(folder, name)); !os.IsNotExist(err) {
+					t.Errorf("%s is still on disk", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFreePercentWithoutVideosFolder(t *testing.T) {
+	previous := config.Conf
+	config.Conf.VideosFolder = filepath.Join(t.TempDir(), "missing")
+	config.Conf.AudiosFolder = t.TempDir()
+	t.Cleanup(func() { config.Conf = previous })
+
+	free, err := FreePercent()
+
+	if err != nil {
+		t.Fatalf("FreePercent in an audio-only setup: %v", err)
+	}
+
+	if free <= 0 || free > 100 {
+		t.Errorf("FreePercent = %v, want a percentage", free)
+	}
+}
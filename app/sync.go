@@ -0,0 +1,59 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"pirecorder/config"
+	"pirecorder/models"
+	"time"
+)
+
+// writeSyncInfo stores the offsets of the first video frame and the first audio
+// sample from the shared recording start in <name>.sync.json next to the video.
+// A positive audioToVideoOffsetMs means the audio starts after the video and
+// should be delayed by that much when muxing.
+func (a *App) writeSyncInfo(name string, start time.Time) {
+	info := models.SyncInfo{
+		Name:      name,
+		VideoFile: fmt.Sprintf("%s.avi", name),
+		AudioFile: fmt.Sprintf("%s.wav", name),
+		StartedAt: start.UTC(),
+	}
+
+	firstFrame := a.camera.FirstFrameTime()
+	firstSample := a.mic.FirstSampleTime()
+
+	if !firstFrame.IsZero() {
+		info.VideoFirstFrameMs = offsetMs(firstFrame.Sub(start))
+	}
+
+	if !firstSample.IsZero() {
+		info.AudioFirstSampleMs = offsetMs(firstSample.Sub(start))
+	}
+
+	if !firstFrame.IsZero() && !firstSample.IsZero() {
+		info.AudioToVideoOffsetMs = offsetMs(firstSample.Sub(firstFrame))
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+
+	if err != nil {
+		a.logger.LogError(err, "Error encoding sync metadata", "filename", name)
+		return
+	}
+
+	filename := fmt.Sprintf("%s/%s.sync.json", config.GetConfig().VideosFolder, name)
+
+	if err = os.WriteFile(filename, data, 0644); err != nil {
+		a.logger.LogError(err, "Error writing sync metadata", "filename", filename)
+		return
+	}
+
+	a.logger.LogInfo("Wrote sync metadata", "filename", filename)
+}
+
+func offsetMs(d time.Duration) *float64 {
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"pirecorder/app/envelope"
+	"time"
+)
+
+// encryptedCopy is the sidecar kept next to an encrypted staging file. The staging
+// file is reused while the source is unchanged, so an interrupted multipart upload
+// of the ciphertext can still be resumed.
+type encryptedCopy struct {
+	Source  string           `json:"source"`
+	Size    int64            `json:"size"`
+	ModTime time.Time        `json:"modTime"`
+	Header  *envelope.Header `json:"header"`
+}
+
+func (u *Uploader) stagingPath(filename string) string {
+	sum := sha1.Sum([]byte(filename))
+	return filepath.Join(u.stagingDir, hex.EncodeToString(sum[:])+".enc")
+}
+
+// encrypt returns the path of an encrypted copy of the file along with the object
+// metadata needed to decrypt it.
+func (u *Uploader) encrypt(path string, filename string, contentType string) (string, map[string]string, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	staging := u.stagingPath(filename)
+	sidecar := staging + ".json"
+
+	var existing encryptedCopy
+	if data, err := os.ReadFile(sidecar); err == nil && json.Unmarshal(data, &existing) == nil {
+		if _, err = os.Stat(staging); err == nil && existing.Source == filename &&
+			existing.Size == info.Size() && existing.ModTime.Equal(info.ModTime()) && existing.Header != nil {
+			return staging, encryptionMetadata(existing.Header, contentType), nil
+		}
+	}
+
+	if err = os.MkdirAll(u.stagingDir, 0700); err != nil {
+		return "", nil, err
+	}
+
+	header, err := u.encrypter.EncryptFile(path, staging)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := json.Marshal(encryptedCopy{
+		Source:  filename,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Header:  header,
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err = os.WriteFile(sidecar, data, 0600); err != nil {
+		return "", nil, err
+	}
+
+	return staging, encryptionMetadata(header, contentType), nil
+}
+
+func (u *Uploader) removeStaging(filename string) {
+	staging := u.stagingPath(filename)
+	_ = os.Remove(staging)
+	_ = os.Remove(staging + ".json")
+}
+
+func encryptionMetadata(header *envelope.Header, contentType string) map[string]string {
+	metadata := header.Metadata()
+	metadata[envelope.MetaContentType] = contentType
+	return metadata
+}
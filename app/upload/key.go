@@ -0,0 +1,283 @@
+package upload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"pirecorder/config"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var placeholders = map[string]bool{
+	"site": true, "device": true, "camera": true,
+	"yyyy": true, "mm": true, "dd": true, "hh": true,
+	"kind": true, "name": true,
+}
+
+// recordingInfo is what is known about a local file when it is uploaded.
+type recordingInfo struct {
+	Kind     string // videos, audios or logs
+	Name     string
+	Start    time.Time
+	Stop     time.Time
+	Duration time.Duration
+}
+
+// validateKeyTemplate rejects templates with placeholders objectKey doesn't know.
+func validateKeyTemplate(template string) error {
+	if !strings.Contains(template, "{name}") {
+		return fmt.Errorf("key template %q must contain {name}", template)
+	}
+
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !placeholders[match[1]] {
+			return fmt.Errorf("unknown placeholder %s in key template", match[0])
+		}
+	}
+
+	return nil
+}
+
+// objectKey renders the configured key template for a file. Dates are taken from
+// the start of the recording in UTC, and empty segments such as an unset {site}
+// are dropped.
+func objectKey(info recordingInfo) string {
+	conf := config.GetConfig()
+	date := info.Start.UTC()
+
+	values := map[string]string{
+		"site":   conf.SiteID,
+		"device": conf.DeviceID,
+		"camera": conf.CameraID,
+		"yyyy":   date.Format("2006"),
+		"mm":     date.Format("01"),
+		"dd":     date.Format("02"),
+		"hh":     date.Format("15"),
+		"kind":   info.Kind,
+		"name":   info.Name,
+	}
+
+	key := placeholderPattern.ReplaceAllStringFunc(conf.StoreConfig.KeyTemplate, func(match string) string {
+		return values[match[1:len(match)-1]]
+	})
+
+	segments := strings.Split(key, "/")
+	kept := segments[:0]
+
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+
+	return path.Join(kept...)
+}
+
+// deviceKeyPrefix returns the leading segments of the key template that are the same
+// for every file of this device, ending in a slash. It is empty unless they include
+// {device}, as the keys of other devices could share the prefix otherwise.
+func deviceKeyPrefix() string {
+	conf := config.GetConfig()
+
+	values := map[string]string{
+		"site":   conf.SiteID,
+		"device": conf.DeviceID,
+		"camera": conf.CameraID,
+	}
+
+	var kept []string
+	hasDevice := false
+
+	for _, segment := range strings.Split(conf.StoreConfig.KeyTemplate, "/") {
+		perFile := false
+
+		rendered := placeholderPattern.ReplaceAllStringFunc(segment, func(match string) string {
+			value, ok := values[match[1:len(match)-1]]
+			perFile = perFile || !ok
+			return value
+		})
+
+		if perFile {
+			break
+		}
+
+		hasDevice = hasDevice || strings.Contains(segment, "{device}")
+
+		if rendered != "" {
+			kept = append(kept, rendered)
+		}
+	}
+
+	if !hasDevice || conf.DeviceID == "" || len(kept) == 0 {
+		return ""
+	}
+
+	return path.Join(kept...) + "/"
+}
+
+// objectMetadata is attached to every upload so bucket lifecycle rules and queries can use it.
+func objectMetadata(info recordingInfo) (map[string]string, map[string]string) {
+	conf := config.GetConfig()
+
+	metadata := map[string]string{
+		"device-id":   conf.DeviceID,
+		"app-version": config.Version,
+	}
+
+	if conf.SiteID != "" {
+		metadata["site-id"] = conf.SiteID
+	}
+
+	if info.Kind == "videos" {
+		metadata["camera-id"] = conf.CameraID
+	}
+
+	if info.Kind != "logs" {
+		metadata["recording-start"] = info.Start.UTC().Format(time.RFC3339)
+		metadata["recording-stop"] = info.Stop.UTC().Format(time.RFC3339)
+		metadata["duration-seconds"] = fmt.Sprintf("%.3f", info.Duration.Seconds())
+	}
+
+	tags := map[string]string{
+		"kind":   info.Kind,
+		"device": conf.DeviceID,
+	}
+
+	if conf.SiteID != "" {
+		tags["site"] = conf.SiteID
+	}
+
+	return metadata, tags
+}
+
+// Inspect returns when a local recording started and stopped and how long it is,
+// the same values that are uploaded in its metadata.
+func Inspect(filename string) (start, stop time.Time, duration time.Duration, err error) {
+	folder, _, kind, ok := recordingTarget(filename)
+
+	if !ok {
+		return start, stop, 0, fmt.Errorf("%s is not a recording", filename)
+	}
+
+	info, err := inspectFile(fmt.Sprintf("%s/%s", folder, filename), filename, kind)
+	return info.Start, info.Stop, info.Duration, err
+}
+
+// inspectFile works out when a recording started and stopped. The stop time is the
+// last modification, the start is the file's creation time where the filesystem
+// records it, otherwise it is derived from the duration in the file headers.
+func inspectFile(filePath string, name string, kind string) (recordingInfo, error) {
+	stat, err := os.Stat(filePath)
+
+	if err != nil {
+		return recordingInfo{}, err
+	}
+
+	info := recordingInfo{
+		Kind: kind,
+		Name: name,
+		Stop: stat.ModTime(),
+	}
+
+	var headerDuration time.Duration
+
+	switch kind {
+	case "audios":
+		headerDuration, err = wavDuration(filePath, stat.Size())
+	case "videos":
+		headerDuration, err = aviDuration(filePath)
+	}
+
+	if err != nil {
+		headerDuration = 0
+	}
+
+	if birth, ok := birthTime(filePath); ok && !birth.After(info.Stop) {
+		info.Start = birth
+		info.Duration = info.Stop.Sub(birth)
+	} else {
+		info.Start = info.Stop.Add(-headerDuration)
+		info.Duration = headerDuration
+	}
+
+	// voice activated clips and padded recordings are best described by their headers
+	if kind == "audios" && headerDuration > 0 {
+		info.Duration = headerDuration
+	}
+
+	return info, nil
+}
+
+func birthTime(filePath string) (time.Time, bool) {
+	var stat unix.Statx_t
+
+	if err := unix.Statx(unix.AT_FDCWD, filePath, 0, unix.STATX_BTIME, &stat); err != nil {
+		return time.Time{}, false
+	}
+
+	if stat.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(stat.Btime.Sec, int64(stat.Btime.Nsec)), true
+}
+
+// wavDuration reads the byte rate from the header written by audio.File.
+func wavDuration(filePath string, size int64) (time.Duration, error) {
+	header, err := readAt(filePath, 28, 4)
+
+	if err != nil {
+		return 0, err
+	}
+
+	byteRate := binary.LittleEndian.Uint32(header)
+
+	if byteRate == 0 || size < 44 {
+		return 0, fmt.Errorf("invalid wav header")
+	}
+
+	return time.Duration(float64(size-44) / float64(byteRate) * float64(time.Second)), nil
+}
+
+// aviDuration reads the frame interval and count from the AVI main header.
+func aviDuration(filePath string) (time.Duration, error) {
+	header, err := readAt(filePath, 24, 28)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if string(header[:4]) != "avih" {
+		return 0, fmt.Errorf("invalid avi header")
+	}
+
+	microSecPerFrame := binary.LittleEndian.Uint32(header[8:12])
+	totalFrames := binary.LittleEndian.Uint32(header[24:28])
+
+	return time.Duration(microSecPerFrame) * time.Duration(totalFrames) * time.Microsecond, nil
+}
+
+func readAt(filePath string, offset int64, length int) ([]byte, error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	buf := make([]byte, length)
+
+	if _, err = file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
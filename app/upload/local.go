@@ -0,0 +1,193 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// metaSuffix is appended to the object path to store the metadata of file based stores.
+const metaSuffix = ".meta.json"
+
+// LocalStore keeps uploads in a directory, e.g. an NFS mount.
+type LocalStore struct {
+	root     string
+	throttle *Throttle
+}
+
+func NewLocalStore(root string, throttle *Throttle) (*LocalStore, error) {
+	if root == "" {
+		return nil, errors.New("local store path not configured")
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{root: root, throttle: throttle}, nil
+}
+
+func (l *LocalStore) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+func (l *LocalStore) Put(input *PutInput) error {
+	dest := l.path(input.Key)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a half written upload never shows up under the key
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = io.Copy(tmp, l.throttle.Reader(input.body())); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), dest); err != nil {
+		return err
+	}
+
+	// the metadata goes next to the object once it is in place, never next to a failed upload
+	return writeMetadata(dest, input.Metadata, os.WriteFile)
+}
+
+func (l *LocalStore) Head(key string) (*Object, error) {
+	dest := l.path(key)
+	info, err := os.Stat(dest)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	metadata, err := readMetadata(dest, os.ReadFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		Metadata:     metadata,
+	}, nil
+}
+
+// Verify re-hashes the stored file.
+func (l *LocalStore) Verify(key string, checksum string, _ io.ReaderAt, _ int64) error {
+	file, err := os.Open(l.path(key))
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	stored, err := hashContent(file)
+
+	if err != nil {
+		return err
+	}
+
+	if stored != checksum {
+		return fmt.Errorf("%w: sha256 %s, expected %s", errChecksumMismatch, stored, checksum)
+	}
+	return nil
+}
+
+func (l *LocalStore) Delete(key string) error {
+	dest := l.path(key)
+	_ = os.Remove(dest + metaSuffix)
+
+	if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *LocalStore) List(prefix string) ([]Object, error) {
+	var objects []Object
+
+	err := filepath.WalkDir(l.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasSuffix(path, metaSuffix) || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.root, path)
+
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+
+	return objects, err
+}
+
+func writeMetadata(dest string, metadata map[string]string, write func(string, []byte, os.FileMode) error) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+
+	if err != nil {
+		return err
+	}
+
+	return write(dest+metaSuffix, data, 0644)
+}
+
+func readMetadata(dest string, read func(string) ([]byte, error)) (map[string]string, error) {
+	data, err := read(dest + metaSuffix)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metadata map[string]string
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
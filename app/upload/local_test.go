@@ -0,0 +1,122 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestLocalStore(t *testing.T) (*LocalStore, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	store, err := NewLocalStore(root, NewThrottle(0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, root
+}
+
+func TestLocalStorePut(t *testing.T) {
+	store, root := newTestLocalStore(t)
+
+	err := store.Put(&PutInput{
+		Key:      "device/audios/clip.wav",
+		Body:     bytes.NewReader([]byte("audio")),
+		Metadata: map[string]string{"sha256": "abc"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "device", "audios", "clip.wav"))
+
+	if err != nil || string(data) != "audio" {
+		t.Fatalf("stored file = %q, %v, want audio", data, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(root, "device", "audios", "clip.wav"+metaSuffix)); err != nil {
+		t.Errorf("metadata file: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "device", "audios"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d entries, want the file and its metadata only", len(entries))
+	}
+}
+
+func TestLocalStoreKeysStayInRoot(t *testing.T) {
+	store, root := newTestLocalStore(t)
+
+	if err := store.Put(&PutInput{Key: "../../escaped.avi", Body: bytes.NewReader([]byte("video"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped.avi")); err != nil {
+		t.Errorf("key with .. was not kept inside the root: %v", err)
+	}
+}
+
+func TestLocalStorePutFailureLeavesNothing(t *testing.T) {
+	store, root := newTestLocalStore(t)
+	failed := errors.New("read failed")
+
+	err := store.Put(&PutInput{
+		Key:      "device/videos/clip.avi",
+		Body:     &failingReader{err: failed},
+		Metadata: map[string]string{"sha256": "abc"},
+	})
+
+	if !errors.Is(err, failed) {
+		t.Fatalf("Put = %v, want %v", err, failed)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "device", "videos"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("failed upload left %d entries behind", len(entries))
+	}
+}
+
+func TestLocalStoreVerifyDetectsCorruption(t *testing.T) {
+	store, root := newTestLocalStore(t)
+	data := []byte("video frames")
+	sum := sha256.Sum256(data)
+
+	if err := store.Put(&PutInput{Key: "clip.avi", Body: bytes.NewReader(data)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "clip.avi"), []byte("video framez"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Verify("clip.avi", hex.EncodeToString(sum[:]), bytes.NewReader(data), int64(len(data)))
+
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("Verify of a corrupted file = %v, want %v", err, errChecksumMismatch)
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (f *failingReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
@@ -0,0 +1,136 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/config"
+	"pirecorder/models"
+	"strings"
+)
+
+// manifestPath is where the capture details of a recording are kept until it is uploaded.
+func manifestPath(filename string) string {
+	return filepath.Join(config.GetConfig().DataFolder, "manifests", filename+".json")
+}
+
+// SaveManifest stores what is known about a recording when it stops, e.g. its
+// format and the request that started it. The rest is filled in on upload.
+func (u *Uploader) SaveManifest(manifest models.RecordingManifest) error {
+	path := manifestPath(manifest.Filename)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// manifest completes the saved manifest of a recording after it has been uploaded,
+// recordings without one, e.g. voice activated clips, get what the uploader knows.
+func (u *Uploader) manifest(info recordingInfo, key string, object *Object) (models.RecordingManifest, error) {
+	var manifest models.RecordingManifest
+	data, err := os.ReadFile(manifestPath(info.Name))
+
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return manifest, err
+	}
+
+	hostname, _ := os.Hostname()
+
+	manifest.Filename = info.Name
+	manifest.Kind = info.Kind
+	manifest.Key = key
+	manifest.Size = object.Size
+	manifest.Checksum = object.Metadata[checksumKey]
+	manifest.Encrypted = u.encrypter != nil
+	manifest.DurationSeconds = info.Duration.Seconds()
+	manifest.Hostname = hostname
+	manifest.DeviceID = config.GetConfig().DeviceID
+	manifest.SiteID = config.GetConfig().SiteID
+	manifest.AppVersion = config.Version
+
+	// the saved times are when the request started and stopped the recording
+	if manifest.StartedAt.IsZero() {
+		manifest.StartedAt = info.Start.UTC()
+		manifest.StoppedAt = info.Stop.UTC()
+	}
+
+	if info.Kind == "videos" {
+		manifest.CameraID = config.GetConfig().CameraID
+		manifest.Sync = readSyncInfo(info.Name)
+	}
+
+	return manifest, nil
+}
+
+// uploadManifest uploads <filename>.json next to an uploaded recording.
+func (u *Uploader) uploadManifest(info recordingInfo, key string, object *Object) error {
+	manifest, err := u.manifest(info, key, object)
+
+	if err != nil {
+		return fmt.Errorf("reading manifest: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	manifestInfo := info
+	manifestInfo.Name = info.Name + ".json"
+	metadata, tags := objectMetadata(info)
+
+	_, err = u.putBytes(data, PutInput{
+		Key:         objectKey(manifestInfo),
+		ContentType: "application/json",
+		Metadata:    metadata,
+		Tags:        tags,
+	})
+
+	return err
+}
+
+func (u *Uploader) removeManifest(filename string) {
+	if err := os.Remove(manifestPath(filename)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		u.logger.LogError(err, "Error removing recording manifest", "file_name", filename)
+	}
+}
+
+// readSyncInfo reads the <name>.sync.json written next to a video, if any.
+func readSyncInfo(filename string) *models.SyncInfo {
+	path := fmt.Sprintf("%s/%s.sync.json", config.GetConfig().VideosFolder, strings.TrimSuffix(filename, filepath.Ext(filename)))
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil
+	}
+
+	var info models.SyncInfo
+
+	if err = json.Unmarshal(data, &info); err != nil {
+		return nil
+	}
+
+	return &info
+}
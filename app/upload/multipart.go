@@ -0,0 +1,387 @@
+package upload
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// checkpoint is the on-disk state of a multipart upload, it is enough to resume
+// the upload after a network drop or a restart.
+type checkpoint struct {
+	Key       string                 `json:"key"`
+	UploadID  string                 `json:"uploadId"`
+	PartSize  int64                  `json:"partSize"`
+	Size      int64                  `json:"size"`
+	ModTime   time.Time              `json:"modTime"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Parts     map[int64]uploadedPart `json:"parts"` // by part number
+}
+
+// uploadedPart is what completing the upload needs to know about a part.
+type uploadedPart struct {
+	ETag           string `json:"etag"`
+	ChecksumSHA256 string `json:"checksumSha256"`
+}
+
+func (s *S3Store) checkpointPath(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.checkpointDir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *S3Store) loadCheckpoint(key string) *checkpoint {
+	data, err := os.ReadFile(s.checkpointPath(key))
+
+	if err != nil {
+		return nil
+	}
+
+	var cp checkpoint
+
+	if err = json.Unmarshal(data, &cp); err != nil || cp.Key != key {
+		return nil
+	}
+
+	return &cp
+}
+
+func (s *S3Store) saveCheckpoint(cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+
+	if err != nil {
+		return err
+	}
+
+	path := s.checkpointPath(cp.Key)
+	tmp := path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// putMultipart uploads a file part by part, checkpointing every completed part.
+// If a checkpoint for the same key and file exists, only the missing parts are sent.
+func (s *S3Store) putMultipart(input *PutInput, file *os.File, info os.FileInfo) error {
+	cp := s.resumeCheckpoint(input.Key, info)
+
+	if cp == nil {
+		out, err := s.client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(input.Key),
+			ACL:         aws.String("private"),
+			ContentType: aws.String(input.ContentType),
+			Metadata:    aws.StringMap(input.Metadata),
+			Tagging:     tagging(input.Tags),
+			// S3 checks every part against its checksum and keeps the composite with the object
+			ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		cp = &checkpoint{
+			Key:       input.Key,
+			UploadID:  aws.StringValue(out.UploadId),
+			PartSize:  s.partSize,
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			CreatedAt: time.Now(),
+			Parts:     make(map[int64]uploadedPart),
+		}
+
+		if err = s.saveCheckpoint(cp); err != nil {
+			return err
+		}
+	} else {
+		s.logger.LogInfo("Resuming multipart upload", "key", input.Key, "completed_parts", fmt.Sprint(len(cp.Parts)))
+
+		for number := range cp.Parts {
+			input.progress(partLength(cp, number))
+		}
+	}
+
+	numParts := (cp.Size + cp.PartSize - 1) / cp.PartSize
+	jobs := make(chan int64)
+
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range jobs {
+				offset := (number - 1) * cp.PartSize
+				length := partLength(cp, number)
+
+				section := io.NewSectionReader(file, offset, length)
+				contentMD5, checksum, err := partChecksums(section)
+
+				var out *s3.UploadPartOutput
+				if err == nil {
+					out, err = s.client.UploadPart(&s3.UploadPartInput{
+						Bucket:         aws.String(s.bucket),
+						Key:            aws.String(cp.Key),
+						UploadId:       aws.String(cp.UploadID),
+						PartNumber:     aws.Int64(number),
+						Body:           section,
+						ContentMD5:     aws.String(contentMD5),
+						ChecksumSHA256: aws.String(checksum),
+					})
+				}
+
+				lock.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					cp.Parts[number] = uploadedPart{ETag: aws.StringValue(out.ETag), ChecksumSHA256: checksum}
+					if err = s.saveCheckpoint(cp); err != nil && firstErr == nil {
+						firstErr = err
+					}
+				}
+				lock.Unlock()
+
+				if err == nil {
+					input.progress(length)
+				}
+			}
+		}()
+	}
+
+	for number := int64(1); number <= numParts; number++ {
+		lock.Lock()
+		_, done := cp.Parts[number]
+		failed := firstErr != nil
+		lock.Unlock()
+
+		if failed {
+			break
+		}
+
+		if !done {
+			jobs <- number
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	parts := make([]*s3.CompletedPart, 0, len(cp.Parts))
+	for number, part := range cp.Parts {
+		parts = append(parts, &s3.CompletedPart{
+			PartNumber:     aws.Int64(number),
+			ETag:           aws.String(part.ETag),
+			ChecksumSHA256: nilIfEmpty(part.ChecksumSHA256),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+
+	_, err := s.client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(cp.Key),
+		UploadId:        aws.String(cp.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_ = os.Remove(s.checkpointPath(cp.Key))
+	return nil
+}
+
+// partLength returns the size of a part, only the last one can be shorter than the part size.
+func partLength(cp *checkpoint, number int64) int64 {
+	if remaining := cp.Size - (number-1)*cp.PartSize; remaining < cp.PartSize {
+		return remaining
+	}
+	return cp.PartSize
+}
+
+// partChecksums returns the base64 MD5 and SHA-256 of a part and rewinds it for the upload.
+func partChecksums(section *io.SectionReader) (string, string, error) {
+	md := md5.New()
+	sha := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(md, sha), section); err != nil {
+		return "", "", err
+	}
+
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(md.Sum(nil)), base64.StdEncoding.EncodeToString(sha.Sum(nil)), nil
+}
+
+// resumeCheckpoint returns the checkpoint of an unfinished upload of the same file,
+// with its parts reconciled against what the server actually has.
+func (s *S3Store) resumeCheckpoint(key string, info os.FileInfo) *checkpoint {
+	cp := s.loadCheckpoint(key)
+
+	if cp == nil {
+		return nil
+	}
+
+	if cp.Size != info.Size() || !cp.ModTime.Equal(info.ModTime()) || cp.PartSize != s.partSize {
+		s.logger.LogInfo("File changed since the last upload attempt, starting over", "key", key)
+		s.abortUpload(cp.Key, cp.UploadID)
+		return nil
+	}
+
+	parts := make(map[int64]uploadedPart)
+
+	err := s.client.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(cp.Key),
+		UploadId: aws.String(cp.UploadID),
+	}, func(page *s3.ListPartsOutput, _ bool) bool {
+		for _, part := range page.Parts {
+			number := aws.Int64Value(part.PartNumber)
+
+			if aws.Int64Value(part.Size) == partLength(cp, number) {
+				parts[number] = uploadedPart{ETag: aws.StringValue(part.ETag), ChecksumSHA256: aws.StringValue(part.ChecksumSHA256)}
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload {
+			_ = os.Remove(s.checkpointPath(key))
+			return nil
+		}
+		// keep what we checkpointed locally, the server will reject bad parts on completion
+		return cp
+	}
+
+	cp.Parts = parts
+	return cp
+}
+
+func (s *S3Store) abortUpload(key string, uploadID string) {
+	_, err := s.client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	if err != nil {
+		s.logger.LogError(err, "Error aborting multipart upload", "key", key)
+	}
+
+	if cp := s.loadCheckpoint(key); cp != nil && cp.UploadID == uploadID {
+		_ = os.Remove(s.checkpointPath(key))
+	}
+}
+
+// abortStaleUploads periodically aborts multipart uploads of this device that were
+// started more than maxAge ago, so abandoned parts don't keep costing storage. A maxAge
+// of 0 disables it, rather than aborting every upload in progress.
+func (s *S3Store) abortStaleUploads(maxAge time.Duration, interval time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+
+	s.abortStale(maxAge)
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.abortStale(maxAge)
+	}
+}
+
+// abortStale aborts the stale uploads found in the local checkpoints and, when the key
+// template gives this device its own prefix, those listed under it. Uploads of other
+// devices writing to the same bucket are never touched.
+func (s *S3Store) abortStale(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+
+	entries, err := os.ReadDir(s.checkpointDir)
+
+	if err != nil {
+		s.logger.LogError(err, "Error reading multipart checkpoints")
+	}
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.checkpointDir, entry.Name()))
+
+		if err != nil {
+			continue
+		}
+
+		var cp checkpoint
+
+		if err = json.Unmarshal(data, &cp); err != nil || cp.UploadID == "" {
+			continue
+		}
+
+		if cp.CreatedAt.Before(cutoff) {
+			s.logger.LogInfo("Aborting stale multipart upload", "key", cp.Key)
+			s.abortUpload(cp.Key, cp.UploadID)
+		}
+	}
+
+	prefix := deviceKeyPrefix()
+
+	if prefix == "" {
+		return
+	}
+
+	err = s.client.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range page.Uploads {
+			if aws.TimeValue(upload.Initiated).Before(cutoff) {
+				s.logger.LogInfo("Aborting stale multipart upload", "key", aws.StringValue(upload.Key))
+				s.abortUpload(aws.StringValue(upload.Key), aws.StringValue(upload.UploadId))
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		s.logger.LogError(err, "Error listing multipart uploads")
+	}
+}
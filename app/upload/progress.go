@@ -0,0 +1,186 @@
+package upload
+
+import (
+	"io"
+	"pirecorder/models"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	phasePreparing = "preparing" // inspecting and encrypting the file
+	phaseUploading = "uploading"
+)
+
+// transfer is the progress of a single file being uploaded.
+type transfer struct {
+	lock      sync.Mutex
+	filename  string
+	key       string
+	phase     string
+	total     int64
+	sent      atomic.Int64
+	startedAt time.Time
+	sendingAt time.Time
+}
+
+func (t *transfer) setPhase(phase string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.phase = phase
+	if phase == phaseUploading {
+		t.sendingAt = time.Now()
+	}
+}
+
+func (t *transfer) start(key string, total int64) {
+	t.lock.Lock()
+	t.key = key
+	t.total = total
+	t.lock.Unlock()
+
+	t.sent.Store(0)
+}
+
+func (t *transfer) add(n int64) {
+	t.sent.Add(n)
+}
+
+func (t *transfer) snapshot() models.UploadProgress {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	progress := models.UploadProgress{
+		Filename:   t.filename,
+		Key:        t.key,
+		Phase:      t.phase,
+		BytesSent:  t.sent.Load(),
+		TotalBytes: t.total,
+		StartedAt:  t.startedAt,
+	}
+
+	if progress.BytesSent > progress.TotalBytes {
+		progress.BytesSent = progress.TotalBytes
+	}
+
+	if progress.TotalBytes > 0 {
+		progress.Percent = float64(progress.BytesSent) / float64(progress.TotalBytes) * 100
+	}
+
+	if elapsed := time.Since(t.sendingAt).Seconds(); t.phase == phaseUploading && elapsed > 0 && progress.BytesSent > 0 {
+		progress.BytesPerSecond = float64(progress.BytesSent) / elapsed
+		eta := float64(progress.TotalBytes-progress.BytesSent) / progress.BytesPerSecond
+		progress.ETASeconds = &eta
+	}
+
+	return progress
+}
+
+// begin registers an upload of filename, it returns false if the file is already being uploaded.
+func (u *Uploader) begin(filename string) (*transfer, bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if _, ok := u.active[filename]; ok {
+		return nil, false
+	}
+
+	t := &transfer{
+		filename:  filename,
+		phase:     phasePreparing,
+		startedAt: time.Now(),
+	}
+	u.active[filename] = t
+	return t, true
+}
+
+func (u *Uploader) end(filename string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	delete(u.active, filename)
+}
+
+func (u *Uploader) isActive(filename string) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	_, ok := u.active[filename]
+	return ok
+}
+
+// Progress returns the uploads in progress, oldest first.
+func (u *Uploader) Progress() []models.UploadProgress {
+	u.lock.Lock()
+	transfers := make([]*transfer, 0, len(u.active))
+	for _, t := range u.active {
+		transfers = append(transfers, t)
+	}
+	u.lock.Unlock()
+
+	progress := make([]models.UploadProgress, 0, len(transfers))
+	for _, t := range transfers {
+		progress = append(progress, t.snapshot())
+	}
+
+	sort.Slice(progress, func(i, j int) bool { return progress[i].StartedAt.Before(progress[j].StartedAt) })
+	return progress
+}
+
+// progressReader reports every read to onRead.
+type progressReader struct {
+	reader io.Reader
+	onRead func(n int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	if n > 0 {
+		r.onRead(int64(n))
+	}
+
+	return n, err
+}
+
+// progressSeeker reports reads like progressReader, but only the bytes read up to
+// the current position count. The SDK reads bodies it can rewind more than once, to
+// sign or retry a request, so seeking back takes back what was reported past it.
+type progressSeeker struct {
+	reader   io.ReadSeeker
+	position int64
+	reported int64
+	onRead   func(n int64)
+}
+
+func (r *progressSeeker) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.position += int64(n)
+
+	if r.position > r.reported {
+		r.onRead(r.position - r.reported)
+		r.reported = r.position
+	}
+
+	return n, err
+}
+
+func (r *progressSeeker) Seek(offset int64, whence int) (int64, error) {
+	position, err := r.reader.Seek(offset, whence)
+
+	if err != nil {
+		return position, err
+	}
+
+	r.position = position
+
+	if r.position < r.reported {
+		r.onRead(r.position - r.reported)
+		r.reported = r.position
+	}
+
+	return position, nil
+}
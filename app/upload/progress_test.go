@@ -0,0 +1,52 @@
+package upload
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestProgressSeekerCountsRereadsOnce(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+	var reported int64
+
+	r := &progressSeeker{reader: bytes.NewReader(data), onRead: func(n int64) { reported += n }}
+
+	// read everything, e.g. to sign the request, then rewind and send it
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	if reported != 0 {
+		t.Errorf("reported %d bytes after rewinding, want 0", reported)
+	}
+
+	// length lookups seek to the end and back without reading
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.CopyN(io.Discard, r, 400); err != nil {
+		t.Fatal(err)
+	}
+
+	if reported != 400 {
+		t.Errorf("reported %d bytes midway, want 400", reported)
+	}
+
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if reported != int64(len(data)) {
+		t.Errorf("reported %d bytes, want %d", reported, len(data))
+	}
+}
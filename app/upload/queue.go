@@ -0,0 +1,190 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/app/helper"
+	"pirecorder/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Queue is a durable list of files waiting to be uploaded. Every change is written
+// to a journal file so pending uploads survive restarts.
+type Queue struct {
+	lock  sync.Mutex
+	path  string
+	items []*models.QueueItem
+	wake  chan struct{}
+}
+
+func NewQueue(path string) (*Queue, error) {
+	q := &Queue{
+		path: path,
+		wake: make(chan struct{}, 1),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return q, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &q.items); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Add queues a file to be uploaded after the given delay, files already in the queue are left alone.
+func (q *Queue) Add(filename string, delay time.Duration) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, item := range q.items {
+		if item.Filename == filename {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	q.items = append(q.items, &models.QueueItem{
+		Filename:   filename,
+		EnqueuedAt: now,
+		NextRetry:  now.Add(delay),
+	})
+
+	q.notify()
+	return q.save()
+}
+
+func (q *Queue) Remove(filename string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, item := range q.items {
+		if item.Filename == filename {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return q.save()
+		}
+	}
+	return nil
+}
+
+// Failed records a failed attempt and schedules the next one with exponential backoff.
+func (q *Queue) Failed(filename string, err error, base time.Duration, max time.Duration) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, item := range q.items {
+		if item.Filename != filename {
+			continue
+		}
+
+		item.Attempts++
+		item.LastError = err.Error()
+		item.NextRetry = time.Now().Add(helper.Backoff(item.Attempts, base, max))
+		q.notify()
+		return q.save()
+	}
+	return nil
+}
+
+// Postpone makes an item due again after delay, without counting it as a failed attempt.
+func (q *Queue) Postpone(filename string, delay time.Duration) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, item := range q.items {
+		if item.Filename != filename {
+			continue
+		}
+
+		item.NextRetry = time.Now().Add(delay)
+		q.notify()
+		return q.save()
+	}
+	return nil
+}
+
+// Next returns the item due for upload soonest, ignoring files skip returns true for.
+// If it isn't due yet, the time left is returned instead.
+func (q *Queue) Next(skip func(filename string) bool) (*models.QueueItem, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var next *models.QueueItem
+	for _, item := range q.items {
+		if skip(item.Filename) {
+			continue
+		}
+		if next == nil || item.NextRetry.Before(next.NextRetry) {
+			next = item
+		}
+	}
+
+	if next == nil {
+		return nil, time.Hour
+	}
+
+	if wait := time.Until(next.NextRetry); wait > 0 {
+		return nil, wait
+	}
+
+	item := *next
+	return &item, 0
+}
+
+func (q *Queue) Items() []models.QueueItem {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	items := make([]models.QueueItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].NextRetry.Before(items[j].NextRetry) })
+	return items
+}
+
+// Wake returns a channel that receives whenever new work is added.
+func (q *Queue) Wake() <-chan struct{} {
+	return q.wake
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save must be called with the lock held.
+func (q *Queue) save() error {
+	data, err := json.MarshalIndent(q.items, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := q.path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,288 @@
+package upload
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+type S3Store struct {
+	bucket        string
+	client        *s3.S3
+	uploader      *s3manager.Uploader
+	partSize      int64
+	concurrency   int
+	checkpointDir string
+	logger        *logger.Logger
+}
+
+// NewS3Store creates an S3 backed store. Files are uploaded in parts of partSize bytes
+// with at most concurrency parts in flight. When the body is an *os.File the parts are
+// read straight from the file, so memory use stays bounded regardless of file size.
+// Multipart uploads of files are checkpointed so they can resume after a failure.
+func NewS3Store(logger *logger.Logger, s3config config.S3, storeConfig config.Store, throttle *Throttle) (*S3Store, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = throttle.DialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(s3config.Region),
+		Credentials:      credentials.NewStaticCredentials(s3config.AccessKey, s3config.SecretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		HTTPClient:       &http.Client{Transport: transport},
+	}
+
+	if s3config.EndpointUrl != "" {
+		awsConfig.Endpoint = aws.String(s3config.EndpointUrl)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := storeConfig.PartSize
+
+	if partSize < s3manager.MinUploadPartSize {
+		partSize = s3manager.MinUploadPartSize
+	}
+
+	concurrency := storeConfig.Concurrency
+
+	if concurrency <= 0 {
+		concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	store := &S3Store{
+		bucket: s3config.Bucket,
+		client: s3.New(sess),
+		uploader: s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+			u.PartSize = partSize
+			u.Concurrency = concurrency
+		}),
+		partSize:      partSize,
+		concurrency:   concurrency,
+		checkpointDir: fmt.Sprintf("%s/multipart", config.GetConfig().DataFolder),
+		logger:        logger,
+	}
+
+	if err = os.MkdirAll(store.checkpointDir, 0755); err != nil {
+		return nil, err
+	}
+
+	go store.abortStaleUploads(storeConfig.MultipartMaxAge, storeConfig.MultipartCleanupInterval)
+
+	return store, nil
+}
+
+// Put uploads files larger than a part in checkpointed parts, reporting progress per
+// completed part. Smaller files and other bodies that can be rewound are sent in a
+// single request whose reads are counted as they go out.
+func (s *S3Store) Put(input *PutInput) error {
+	if file, ok := input.Body.(*os.File); ok {
+		info, err := file.Stat()
+
+		if err != nil {
+			return err
+		}
+
+		if info.Size() > s.partSize {
+			return s.putMultipart(input, file, info)
+		}
+	}
+
+	body := input.body()
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		_, err := s.client.PutObject(&s3.PutObjectInput{
+			Bucket:         aws.String(s.bucket),
+			Key:            aws.String(input.Key),
+			ACL:            aws.String("private"),
+			Body:           seeker,
+			ContentType:    aws.String(input.ContentType),
+			ContentMD5:     nilIfEmpty(input.ContentMD5),
+			ChecksumSHA256: nilIfEmpty(input.ChecksumSHA256),
+			Metadata:       aws.StringMap(input.Metadata),
+			Tagging:        tagging(input.Tags),
+		})
+		return err
+	}
+
+	// the uploader buffers bodies that can't be rewound, part by part
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(input.Key),
+		ACL:         aws.String("private"),
+		Body:        body,
+		ContentType: aws.String(input.ContentType),
+		ContentMD5:  nilIfEmpty(input.ContentMD5),
+		Metadata:    aws.StringMap(input.Metadata),
+		Tagging:     tagging(input.Tags),
+		// the uploader only sends it along when the body fits in a single part
+		ChecksumSHA256: nilIfEmpty(input.ChecksumSHA256),
+	})
+	return err
+}
+
+func (s *S3Store) Head(key string) (*Object, error) {
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+		Metadata:     lowerKeys(aws.StringValueMap(out.Metadata)),
+	}, nil
+}
+
+// Verify compares the object with the local content using what S3 computed itself:
+// the SHA-256 validated on upload, or the ETag for objects stored without a checksum.
+func (s *S3Store) Verify(key string, checksum string, content io.ReaderAt, size int64) error {
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	name, remote := "sha256", aws.StringValue(out.ChecksumSHA256)
+	var expected string
+
+	switch {
+	case remote != "" && !strings.Contains(remote, "-"):
+		sum, err := hex.DecodeString(checksum)
+
+		if err != nil {
+			return err
+		}
+		expected = base64.StdEncoding.EncodeToString(sum)
+	case remote != "":
+		expected, err = s.expectedDigest(remote, sha256.New, base64.StdEncoding.EncodeToString, content, size)
+	default:
+		// the ETag is the MD5 of the content unless the object is encrypted with KMS
+		name, remote = "etag", strings.Trim(aws.StringValue(out.ETag), `"`)
+		expected, err = s.expectedDigest(remote, md5.New, hex.EncodeToString, content, size)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if remote != expected {
+		return fmt.Errorf("%w: %s %s, expected %s", errChecksumMismatch, name, remote, expected)
+	}
+	return nil
+}
+
+// expectedDigest computes from the local content the digest S3 reports as remote: the hash
+// of the whole content, or for multipart uploads, marked by a "-<parts>" suffix, the hash
+// of the part hashes.
+func (s *S3Store) expectedDigest(remote string, newHash func() hash.Hash, encode func([]byte) string,
+	content io.ReaderAt, size int64) (string, error) {
+	if strings.Contains(remote, "-") {
+		sum, parts, err := compositeChecksum(newHash, content, size, s.partSize)
+
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s-%d", encode(sum), parts), nil
+	}
+
+	h := newHash()
+
+	if _, err := io.Copy(h, io.NewSectionReader(content, 0, size)); err != nil {
+		return "", err
+	}
+	return encode(h.Sum(nil)), nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Store) List(prefix string) ([]Object, error) {
+	var objects []Object
+
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+
+	return objects, err
+}
+
+// tagging encodes object tags as the query string S3 expects.
+func tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return aws.String(values.Encode())
+}
+
+// lowerKeys undoes the canonicalisation S3 applies to user metadata keys.
+func lowerKeys(metadata map[string]string) map[string]string {
+	lowered := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		lowered[strings.ToLower(key)] = value
+	}
+	return lowered
+}
+
+func nilIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
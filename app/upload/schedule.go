@@ -0,0 +1,113 @@
+package upload
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// window is a daily time range in minutes after local midnight. A window whose
+// end is before its start runs over midnight, e.g. 22:00-06:00.
+type window struct {
+	start int
+	end   int
+}
+
+// Schedule holds the time-of-day windows the background uploader may run in.
+// An empty schedule allows uploads at any time.
+type Schedule struct {
+	windows []window
+}
+
+// ParseSchedule parses comma separated windows such as "22:00-06:00,12:00-13:00".
+func ParseSchedule(spec string) (*Schedule, error) {
+	schedule := &Schedule{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		from, to, found := strings.Cut(part, "-")
+
+		if !found {
+			return nil, fmt.Errorf("invalid upload window %q, expected HH:MM-HH:MM", part)
+		}
+
+		start, err := parseClock(from)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload window %q: %w", part, err)
+		}
+
+		end, err := parseClock(to)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload window %q: %w", part, err)
+		}
+
+		if start == end {
+			return nil, fmt.Errorf("upload window %q is empty", part)
+		}
+
+		schedule.windows = append(schedule.windows, window{start: start, end: end})
+	}
+
+	return schedule, nil
+}
+
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// Open reports whether uploads are allowed at t.
+func (s *Schedule) Open(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range s.windows {
+		if w.start < w.end && minute >= w.start && minute < w.end {
+			return true
+		}
+		if w.start > w.end && (minute >= w.start || minute < w.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextOpen returns when the next window opens after t, or t itself if one is open.
+func (s *Schedule) NextOpen(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+
+	var next time.Time
+
+	for _, w := range s.windows {
+		// built from the wall clock, days with a DST change aren't 24 hours long
+		opens := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+
+		if !opens.After(t) {
+			opens = time.Date(t.Year(), t.Month(), t.Day()+1, w.start/60, w.start%60, 0, 0, t.Location())
+		}
+
+		if next.IsZero() || opens.Before(next) {
+			next = opens
+		}
+	}
+
+	return next
+}
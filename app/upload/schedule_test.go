@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"testing"
+	"time"
+)
+
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-05-01 "+clock, time.UTC)
+
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestScheduleOpen(t *testing.T) {
+	tests := []struct {
+		spec  string
+		clock string
+		open  bool
+	}{
+		{"", "12:00", true},
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"22:00-06:00", "21:59", false},
+		{"22:00-06:00", "22:00", true},
+		{"22:00-06:00", "23:59", true},
+		{"22:00-06:00", "00:00", true},
+		{"22:00-06:00", "05:59", true},
+		{"22:00-06:00", "06:00", false},
+		{"22:00-06:00", "12:00", false},
+		{"22:00-06:00, 12:00-13:00", "12:30", true},
+		{"22:00-06:00, 12:00-13:00", "13:30", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec+" at "+test.clock, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if open := schedule.Open(at(test.clock)); open != test.open {
+				t.Errorf("Open = %v, want %v", open, test.open)
+			}
+		})
+	}
+}
+
+func TestScheduleNextOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		now  time.Time
+		want time.Time
+	}{
+		{"open now", "22:00-06:00", at("23:00"), at("23:00")},
+		{"later today", "22:00-06:00", at("12:00"), at("22:00")},
+		{"across midnight", "01:00-02:00", at("23:30"), at("01:00").AddDate(0, 0, 1)},
+		{"right after closing", "01:00-02:00", at("02:00"), at("01:00").AddDate(0, 0, 1)},
+		{"soonest of several", "22:00-06:00, 12:00-13:00", at("07:00"), at("12:00")},
+		{"across the month", "01:00-02:00",
+			time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 1, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if next := schedule.NextOpen(test.now); !next.Equal(test.want) {
+				t.Errorf("NextOpen(%v) = %v, want %v", test.now, next, test.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextOpenOnDSTChange(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+
+	if err != nil {
+		t.Skip("time zone data not available:", err)
+	}
+
+	schedule, err := ParseSchedule("08:00-09:00")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+	}{
+		{"clocks go forward", time.Date(2024, 3, 31, 0, 30, 0, 0, location)},
+		{"clocks go back", time.Date(2024, 10, 27, 0, 30, 0, 0, location)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := schedule.NextOpen(test.now)
+
+			if next.Hour() != 8 || next.Minute() != 0 || next.Day() != test.now.Day() {
+				t.Errorf("NextOpen(%v) = %v, want 08:00 the same day", test.now, next)
+			}
+		})
+	}
+}
+
+func TestParseScheduleRejects(t *testing.T) {
+	for _, spec := range []string{"22:00", "25:00-06:00", "22:00-22:00", "evening-morning"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
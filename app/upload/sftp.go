@@ -0,0 +1,322 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPStore uploads to a directory on a remote host over SFTP.
+type SFTPStore struct {
+	lock      sync.Mutex
+	address   string
+	root      string
+	sshConfig *ssh.ClientConfig
+	conn      *ssh.Client
+	client    *sftp.Client
+	throttle  *Throttle
+}
+
+func NewSFTPStore(logger *logger.Logger, sftpConfig config.SFTP, throttle *Throttle) (*SFTPStore, error) {
+	if sftpConfig.Host == "" {
+		return nil, errors.New("sftp host not configured")
+	}
+
+	var auth []ssh.AuthMethod
+
+	if sftpConfig.KeyFile != "" {
+		key, err := os.ReadFile(sftpConfig.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if sftpConfig.Password != "" {
+		auth = append(auth, ssh.Password(sftpConfig.Password))
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+
+	switch {
+	case sftpConfig.KnownHosts != "":
+		callback, err := knownhosts.New(sftpConfig.KnownHosts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		hostKeyCallback = callback
+	case sftpConfig.InsecureIgnoreHostKey:
+		logger.LogWarning(errors.New("sftp host key not verified"), "SFTP_INSECURE_IGNORE_HOST_KEY is set, any server can pose as the sftp host", "host", sftpConfig.Host)
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("sftp known hosts file not configured, set SFTP_KNOWN_HOSTS")
+	}
+
+	address := sftpConfig.Host
+
+	if !strings.Contains(address, ":") {
+		address = fmt.Sprintf("%s:22", address)
+	}
+
+	return &SFTPStore{
+		address: address,
+		root:    sftpConfig.Path,
+		sshConfig: &ssh.ClientConfig{
+			User:            sftpConfig.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		throttle: throttle,
+	}, nil
+}
+
+// session returns a connected sftp client, dialing a new connection if the previous one was dropped.
+func (s *SFTPStore) session() (*sftp.Client, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.client != nil {
+		if _, err := s.client.Getwd(); err == nil {
+			return s.client, nil
+		}
+		s.closeLocked()
+	}
+
+	dial := s.throttle.DialContext(&net.Dialer{Timeout: s.sshConfig.Timeout})
+	netConn, err := dial(context.Background(), "tcp", s.address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, s.address, s.sshConfig)
+
+	if err != nil {
+		_ = netConn.Close()
+		return nil, err
+	}
+
+	conn := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(conn)
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	s.conn = conn
+	s.client = client
+	return client, nil
+}
+
+func (s *SFTPStore) closeLocked() {
+	if s.client != nil {
+		_ = s.client.Close()
+	}
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.client, s.conn = nil, nil
+}
+
+func (s *SFTPStore) path(key string) string {
+	return path.Join(s.root, path.Clean("/"+key))
+}
+
+func (s *SFTPStore) Put(input *PutInput) error {
+	client, err := s.session()
+
+	if err != nil {
+		return err
+	}
+
+	dest := s.path(input.Key)
+
+	if err = client.MkdirAll(path.Dir(dest)); err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(dest), fmt.Sprintf(".upload-%d", time.Now().UnixNano()))
+	file, err := client.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(file, input.body()); err != nil {
+		_ = file.Close()
+		_ = client.Remove(tmp)
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		_ = client.Remove(tmp)
+		return err
+	}
+
+	if err = client.PosixRename(tmp, dest); err != nil {
+		_ = client.Remove(tmp)
+		return err
+	}
+
+	return writeMetadata(dest, input.Metadata, func(name string, data []byte, _ os.FileMode) error {
+		meta, err := client.Create(name)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = meta.Write(data); err != nil {
+			_ = meta.Close()
+			return err
+		}
+		return meta.Close()
+	})
+}
+
+func (s *SFTPStore) Head(key string) (*Object, error) {
+	client, err := s.session()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dest := s.path(key)
+	info, err := client.Stat(dest)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+
+	metadata, err := readMetadata(dest, func(name string) ([]byte, error) {
+		meta, err := client.Open(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = meta.Close() }()
+		return io.ReadAll(meta)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		Metadata:     metadata,
+	}, nil
+}
+
+// Verify re-hashes the stored file, reading it back from the remote host.
+func (s *SFTPStore) Verify(key string, checksum string, _ io.ReaderAt, _ int64) error {
+	client, err := s.session()
+
+	if err != nil {
+		return err
+	}
+
+	file, err := client.Open(s.path(key))
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	stored, err := hashContent(file)
+
+	if err != nil {
+		return err
+	}
+
+	if stored != checksum {
+		return fmt.Errorf("%w: sha256 %s, expected %s", errChecksumMismatch, stored, checksum)
+	}
+	return nil
+}
+
+func (s *SFTPStore) Delete(key string) error {
+	client, err := s.session()
+
+	if err != nil {
+		return err
+	}
+
+	dest := s.path(key)
+	_ = client.Remove(dest + metaSuffix)
+
+	if err = client.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *SFTPStore) List(prefix string) ([]Object, error) {
+	client, err := s.session()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Object
+	walker := client.Walk(s.path(""))
+
+	for walker.Step() {
+		if err = walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		info := walker.Stat()
+		name := path.Base(walker.Path())
+
+		if info.IsDir() || strings.HasSuffix(name, metaSuffix) || strings.HasPrefix(name, ".upload-") {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.path("")), "/")
+
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		objects = append(objects, Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,163 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"pirecorder/models"
+	"sync"
+	"time"
+)
+
+const (
+	statePending   = "pending"
+	stateUploading = "uploading"
+	stateUploaded  = "uploaded"
+	stateFailed    = "failed"
+)
+
+// States is the durable upload state of every local recording, kept by filename.
+type States struct {
+	lock   sync.Mutex
+	path   string
+	states map[string]*models.UploadState
+}
+
+func NewStates(path string) (*States, error) {
+	s := &States{
+		path:   path,
+		states: make(map[string]*models.UploadState),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &s.states); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *States) Get(filename string) (models.UploadState, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.states[filename]
+
+	if !ok {
+		return models.UploadState{}, false
+	}
+
+	return *state, true
+}
+
+// update applies change to the state of filename, creating it if needed.
+func (s *States) update(filename string, change func(state *models.UploadState)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.states[filename]
+
+	if !ok {
+		state = &models.UploadState{}
+		s.states[filename] = state
+	}
+
+	change(state)
+	state.UpdatedAt = time.Now()
+	return s.save()
+}
+
+func (s *States) Pending(filename string) error {
+	return s.update(filename, func(state *models.UploadState) {
+		state.Status = statePending
+	})
+}
+
+func (s *States) Uploading(filename string, key string) error {
+	return s.update(filename, func(state *models.UploadState) {
+		state.Status = stateUploading
+		state.Key = key
+		state.Error = ""
+	})
+}
+
+func (s *States) Uploaded(filename string, key string, info os.FileInfo) error {
+	return s.update(filename, func(state *models.UploadState) {
+		now := time.Now()
+		state.Status = stateUploaded
+		state.Key = key
+		state.Error = ""
+		state.UploadedAt = &now
+		state.Size = info.Size()
+		state.ModTime = info.ModTime()
+	})
+}
+
+func (s *States) Failed(filename string, err error) error {
+	return s.update(filename, func(state *models.UploadState) {
+		state.Status = stateFailed
+		state.Error = err.Error()
+	})
+}
+
+func (s *States) Remove(filename string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.states[filename]; !ok {
+		return nil
+	}
+
+	delete(s.states, filename)
+	return s.save()
+}
+
+// Prune drops the state of recordings that no longer exist locally.
+func (s *States) Prune(exists func(filename string) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pruned := false
+	for filename := range s.states {
+		if !exists(filename) {
+			delete(s.states, filename)
+			pruned = true
+		}
+	}
+
+	if !pruned {
+		return nil
+	}
+	return s.save()
+}
+
+// save must be called with the lock held.
+func (s *States) save() error {
+	data, err := json.MarshalIndent(s.states, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
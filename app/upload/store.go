@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Store is a place recordings and logs can be uploaded to.
+type Store interface {
+	Put(input *PutInput) error
+	Head(key string) (*Object, error)
+	Delete(key string) error
+	List(prefix string) ([]Object, error)
+	// Verify checks the stored object against the local content, whose hex SHA-256 is
+	// checksum, with a checksum the backend computes from what it actually stored.
+	Verify(key string, checksum string, content io.ReaderAt, size int64) error
+}
+
+type PutInput struct {
+	Key            string
+	Body           io.Reader
+	ContentType    string
+	ContentMD5     string // base64, lets the backend reject corrupted bodies where supported
+	ChecksumSHA256 string // base64, validated and kept by backends that checksum objects themselves
+	Metadata       map[string]string
+	Tags           map[string]string // only S3 supports object tags, other backends ignore them
+	Progress       func(n int64)     // optional, called with the number of bytes sent as the upload proceeds, negative when a send is retried
+}
+
+func (p *PutInput) progress(n int64) {
+	if p.Progress != nil && n > 0 {
+		p.Progress(n)
+	}
+}
+
+// body returns the input body, counting reads towards the progress. Bodies that
+// can be rewound stay seekable.
+func (p *PutInput) body() io.Reader {
+	if p.Progress == nil {
+		return p.Body
+	}
+
+	if seeker, ok := p.Body.(io.ReadSeeker); ok {
+		return &progressSeeker{reader: seeker, onRead: p.Progress}
+	}
+	return &progressReader{reader: p.Body, onRead: p.Progress}
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// NewStore creates the configured backend, sending everything through throttle.
+func NewStore(logger *logger.Logger, storeConfig config.Store, throttle *Throttle) (Store, error) {
+	switch storeConfig.Backend {
+	case "", "s3":
+		return NewS3Store(logger, config.GetConfig().S3Config, storeConfig, throttle)
+	case "local":
+		return NewLocalStore(storeConfig.LocalPath, throttle)
+	case "sftp":
+		return NewSFTPStore(logger, storeConfig.SFTP, throttle)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", storeConfig.Backend)
+	}
+}
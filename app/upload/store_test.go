@@ -0,0 +1,308 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// testStore is the contract every Store has to fulfil.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	data := []byte("some recorded bytes")
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data)
+	checksum := hex.EncodeToString(sha[:])
+
+	err := store.Put(&PutInput{
+		Key:            "device/videos/clip.avi",
+		Body:           bytes.NewReader(data),
+		ContentType:    "video/x-msvideo",
+		ContentMD5:     base64.StdEncoding.EncodeToString(md[:]),
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sha[:]),
+		Metadata:       map[string]string{"device-id": "device"},
+	})
+
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	object, err := store.Head("device/videos/clip.avi")
+
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+
+	if object.Size != int64(len(data)) {
+		t.Errorf("Head size = %d, want %d", object.Size, len(data))
+	}
+
+	if object.Metadata["device-id"] != "device" {
+		t.Errorf("Head metadata = %v, want device-id=device", object.Metadata)
+	}
+
+	if err = store.Verify("device/videos/clip.avi", checksum, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Errorf("Verify of the uploaded content: %v", err)
+	}
+
+	other := []byte("other recorded bytes")
+	otherSum := sha256.Sum256(other)
+	err = store.Verify("device/videos/clip.avi", hex.EncodeToString(otherSum[:]), bytes.NewReader(other), int64(len(other)))
+
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("Verify of different content = %v, want %v", err, errChecksumMismatch)
+	}
+
+	if _, err = store.Head("device/videos/missing.avi"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head of a missing key = %v, want %v", err, ErrObjectNotFound)
+	}
+
+	if err = store.Put(&PutInput{Key: "device/audios/clip.wav", Body: bytes.NewReader(data)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	objects, err := store.List("device/videos/")
+
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Key != "device/videos/clip.avi" || objects[0].Size != int64(len(data)) {
+		t.Errorf("List = %+v, want only device/videos/clip.avi", objects)
+	}
+
+	if err = store.Delete("device/videos/clip.avi"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err = store.Head("device/videos/clip.avi"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head after Delete = %v, want %v", err, ErrObjectNotFound)
+	}
+
+	if err = store.Delete("device/videos/clip.avi"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+}
+
+func TestLocalStoreContract(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), NewThrottle(0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func TestSFTPStoreContract(t *testing.T) {
+	// the store talks to an in-process sftp server through pipes instead of ssh
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server goes first, closing its end is what stops the client
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	testStore(t, &SFTPStore{root: t.TempDir(), client: client, throttle: NewThrottle(0)})
+}
+
+func TestSFTPStoreRequiresKnownHosts(t *testing.T) {
+	_, err := NewSFTPStore(logger.NewDiscardLogger(), config.SFTP{Host: "backup.example.com", User: "pirecorder"}, NewThrottle(0))
+
+	if err == nil {
+		t.Fatal("NewSFTPStore without known hosts succeeded, want an error")
+	}
+
+	store, err := NewSFTPStore(logger.NewDiscardLogger(), config.SFTP{Host: "backup.example.com", InsecureIgnoreHostKey: true}, NewThrottle(0))
+
+	if err != nil {
+		t.Fatalf("NewSFTPStore with SFTP_INSECURE_IGNORE_HOST_KEY: %v", err)
+	}
+
+	if store.address != "backup.example.com:22" {
+		t.Errorf("address = %q, want backup.example.com:22", store.address)
+	}
+}
+
+func TestS3StoreContract(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	previous := config.Conf
+	config.Conf.DataFolder = t.TempDir()
+	t.Cleanup(func() { config.Conf = previous })
+
+	store, err := NewS3Store(logger.NewDiscardLogger(), config.S3{
+		Bucket:      "recordings",
+		AccessKey:   "key",
+		SecretKey:   "secret",
+		Region:      "us-east-1",
+		EndpointUrl: server.URL,
+	}, config.Store{}, NewThrottle(0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+
+	// small files are sent in one request, their progress has to add up all the same
+	data := bytes.Repeat([]byte("frame"), 1000)
+	var sent int64
+
+	err = store.Put(&PutInput{Key: "device/videos/small.avi", Body: bytes.NewReader(data), Progress: func(n int64) { sent += n }})
+
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if sent != int64(len(data)) {
+		t.Errorf("progress reported %d bytes, want %d", sent, len(data))
+	}
+}
+
+// fakeS3 is a stand-in for the few S3 calls single part uploads make. Like S3 it
+// rejects bodies that don't match their checksums and keeps the SHA-256 it was sent.
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data     []byte
+	metadata http.Header
+	checksum string
+	modified time.Time
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// path style: /bucket/key
+	key := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+
+	switch {
+	case r.Method == http.MethodGet && len(key) == 1 && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut && len(key) == 2 && len(r.URL.Query()) == 0:
+		f.put(w, r, key[1])
+	case r.Method == http.MethodHead && len(key) == 2:
+		object, ok := f.objects[key[1]]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		for name, values := range object.metadata {
+			w.Header()[name] = values
+		}
+
+		if r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" && object.checksum != "" {
+			w.Header().Set("X-Amz-Checksum-Sha256", object.checksum)
+		}
+
+		sum := md5.Sum(object.data)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum))
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.data)))
+	case r.Method == http.MethodDelete && len(key) == 2:
+		delete(f.objects, key[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := io.ReadAll(r.Body)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	md := md5.Sum(data)
+	sha := sha256.Sum256(data)
+	checksum := r.Header.Get("X-Amz-Checksum-Sha256")
+
+	if contentMD5 := r.Header.Get("Content-Md5"); contentMD5 != "" && contentMD5 != base64.StdEncoding.EncodeToString(md[:]) ||
+		checksum != "" && checksum != base64.StdEncoding.EncodeToString(sha[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("<Error><Code>BadDigest</Code></Error>"))
+		return
+	}
+
+	metadata := make(http.Header)
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			metadata[name] = values
+		}
+	}
+
+	f.objects[key] = fakeObject{data: data, metadata: metadata, checksum: checksum, modified: time.Now()}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md))
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []content
+	}{}
+
+	for key, object := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(object.data), LastModified: object.modified.UTC().Format(time.RFC3339)})
+		}
+	}
+
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
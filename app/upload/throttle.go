@@ -0,0 +1,164 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// throughputWindow is how far back the measured throughput looks.
+const throughputWindow = 5 * time.Second
+
+// Throttle limits the rate uploads are sent at, shared across every upload and
+// backend, and measures the actual throughput. A rate of 0 only measures.
+type Throttle struct {
+	lock    sync.Mutex
+	rate    float64 // bytes per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	samples []sample
+}
+
+type sample struct {
+	at    time.Time
+	bytes int
+}
+
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	burst := float64(bytesPerSecond) / 4 // a quarter of a second worth of data
+
+	if burst < 16*1024 {
+		burst = 16 * 1024
+	}
+
+	return &Throttle{
+		rate:  float64(bytesPerSecond),
+		burst: burst,
+		last:  time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent, n must not exceed the burst size.
+func (t *Throttle) wait(n int) {
+	t.lock.Lock()
+	now := time.Now()
+	t.record(now, n)
+
+	if t.rate <= 0 {
+		t.lock.Unlock()
+		return
+	}
+
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+	t.tokens -= float64(n)
+
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.lock.Unlock()
+
+	time.Sleep(delay)
+}
+
+// chunk splits writes so a single call never takes more than the burst.
+func (t *Throttle) chunk(n int) int {
+	if t.rate > 0 && float64(n) > t.burst {
+		return int(t.burst)
+	}
+	return n
+}
+
+// record must be called with the lock held.
+func (t *Throttle) record(now time.Time, n int) {
+	cutoff := now.Add(-throughputWindow)
+	kept := t.samples[:0]
+
+	for _, s := range t.samples {
+		if s.at.After(cutoff) {
+			kept = append(kept, s)
+		}
+	}
+
+	t.samples = append(kept, sample{at: now, bytes: n})
+}
+
+// Throughput returns the bytes per second sent over the last few seconds.
+func (t *Throttle) Throughput() float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	cutoff := time.Now().Add(-throughputWindow)
+	total := 0
+
+	for _, s := range t.samples {
+		if s.at.After(cutoff) {
+			total += s.bytes
+		}
+	}
+
+	return float64(total) / throughputWindow.Seconds()
+}
+
+// Reader limits how fast r can be read from.
+func (t *Throttle) Reader(r io.Reader) io.Reader {
+	return &throttledReader{reader: r, throttle: t}
+}
+
+// DialContext dials connections whose writes are limited, for use in transports.
+func (t *Throttle) DialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &throttledConn{Conn: conn, throttle: t}, nil
+	}
+}
+
+type throttledReader struct {
+	reader   io.Reader
+	throttle *Throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p[:r.throttle.chunk(len(p))])
+
+	if n > 0 {
+		r.throttle.wait(n)
+	}
+
+	return n, err
+}
+
+type throttledConn struct {
+	net.Conn
+	throttle *Throttle
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+
+	for written < len(p) {
+		n := c.throttle.chunk(len(p) - written)
+		c.throttle.wait(n)
+
+		n, err := c.Conn.Write(p[written : written+n])
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
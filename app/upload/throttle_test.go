@@ -0,0 +1,60 @@
+package upload
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestThrottleRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  int64
+		bytes int
+	}{
+		{"slow", 64 * 1024, 64 * 1024},
+		{"fast", 1024 * 1024, 768 * 1024},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			throttle := NewThrottle(test.rate)
+			started := time.Now()
+
+			n, err := io.Copy(io.Discard, throttle.Reader(bytes.NewReader(make([]byte, test.bytes))))
+
+			if err != nil || n != int64(test.bytes) {
+				t.Fatalf("copied %d bytes, %v, want %d", n, err, test.bytes)
+			}
+
+			// a burst may go out straight away, the rest is held to the rate
+			elapsed := time.Since(started)
+			least := time.Duration(float64(test.bytes)-throttle.burst) * time.Second / time.Duration(test.rate)
+			most := time.Duration(test.bytes)*time.Second/time.Duration(test.rate) + 500*time.Millisecond
+
+			if elapsed < least || elapsed > most {
+				t.Errorf("sending %d bytes at %d bytes/s took %v, want between %v and %v", test.bytes, test.rate, elapsed, least, most)
+			}
+		})
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	throttle := NewThrottle(0)
+	started := time.Now()
+
+	if _, err := io.Copy(io.Discard, throttle.Reader(bytes.NewReader(make([]byte, 8*1024*1024)))); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("unlimited throttle took %v for 8 MiB", elapsed)
+	}
+
+	want := 8 * 1024 * 1024 / throughputWindow.Seconds()
+
+	if got := throttle.Throughput(); got != want {
+		t.Errorf("Throughput = %v, want %v", got, want)
+	}
+}
@@ -1,107 +1,178 @@
 package upload
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
+	"path/filepath"
+	"pirecorder/app/envelope"
 	"pirecorder/app/helper"
+	"pirecorder/app/webhook"
 	"pirecorder/apperror"
 	"pirecorder/config"
 	"pirecorder/logger"
-	"sort"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"pirecorder/models"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Uploader struct {
-	isUploading      bool
-	videoIsRecording bool
-	uploadName       string
+	lock             sync.Mutex
+	active           map[string]*transfer // uploads in progress by filename
+	slots            chan struct{}        // one per upload running at once, shared by all uploaders of recordings
+	videoIsRecording atomic.Bool          // set from the request handlers, read by the queue
 	logger           *logger.Logger
-	uploader         *s3manager.Uploader
+	store            Store
+	queue            *Queue
+	encrypter        *envelope.Encrypter // nil when recordings are uploaded as is
+	stagingDir       string
+	notifier         *webhook.Notifier // nil when no webhook is configured
+	states           *States
+	onUploaded       func(filename, key string, deleted bool)
+	isWriting        func(filename string) bool // nil until CheckWriting is called
+	throttle         *Throttle
+	schedule         *Schedule
 }
 
 func NewUploader(logger *logger.Logger) (*Uploader, error) {
-	s3config := config.GetConfig().S3Config
+	storeConfig := config.GetConfig().StoreConfig
+	throttle := NewThrottle(storeConfig.RateLimit)
+	store, err := NewStore(logger, storeConfig, throttle)
+
+	if err != nil {
+		return nil, err
+	}
 
-	awsConfig := &aws.Config{
-		Region:           aws.String(s3config.Region),
-		Credentials:      credentials.NewStaticCredentials(s3config.AccessKey, s3config.SecretKey, ""),
-		S3ForcePathStyle: aws.Bool(true),
+	schedule, err := ParseSchedule(storeConfig.Windows)
+
+	if err != nil {
+		logger.LogError(err, "Invalid upload windows")
+		return nil, err
 	}
 
-	if s3config.EndpointUrl != "" {
-		awsConfig.Endpoint = aws.String(s3config.EndpointUrl)
+	if err = validateKeyTemplate(config.GetConfig().StoreConfig.KeyTemplate); err != nil {
+		logger.LogError(err, "Invalid upload key template")
+		return nil, err
 	}
 
-	sess, err := session.NewSession(awsConfig)
+	workers := storeConfig.Workers
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue, err := NewQueue(fmt.Sprintf("%s/upload-queue.json", config.GetConfig().DataFolder))
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Uploader{
-		logger:   logger,
-		uploader: s3manager.NewUploader(sess),
-	}, nil
-}
+	encryption := config.GetConfig().Encryption
+	encrypter, err := envelope.NewEncrypter(encryption.PublicKeyFile, encryption.KeyFile)
 
-func (u *Uploader) UploadStats() (bool, string) {
-	return u.isUploading, u.uploadName
-}
+	if err != nil {
+		logger.LogError(err, "Error loading encryption key")
+		return nil, err
+	}
 
-func (u *Uploader) UploadLogs() {
-	s3config := config.GetConfig().S3Config
-	logFolder := config.GetConfig().LogFolder
+	if encrypter != nil {
+		logger.LogInfo("Recordings will be encrypted before upload")
+	}
+
+	states, err := NewStates(fmt.Sprintf("%s/upload-state.json", config.GetConfig().DataFolder))
+
+	if err != nil {
+		logger.LogError(err, "Error loading upload state")
+		return nil, err
+	}
 
-	u.logger.LogInfo("Uploading logs to S3", "bucket", s3config.Bucket, "folder", logFolder)
+	if err = states.Prune(localExists); err != nil {
+		logger.LogError(err, "Error saving upload state")
+	}
 
-	dir, err := os.Open(logFolder)
+	notifier, err := webhook.NewNotifier(logger, config.GetConfig().Webhook,
+		fmt.Sprintf("%s/webhook-deliveries.json", config.GetConfig().DataFolder))
 
 	if err != nil {
-		u.logger.LogError(err, "Error opening log folder", "folder", logFolder)
-		return
+		logger.LogError(err, "Error loading webhook delivery log")
+		return nil, err
 	}
 
-	defer func() { _ = dir.Close() }()
+	u := &Uploader{
+		active:     make(map[string]*transfer),
+		slots:      make(chan struct{}, workers),
+		logger:     logger,
+		store:      store,
+		queue:      queue,
+		encrypter:  encrypter,
+		stagingDir: filepath.Join(config.GetConfig().DataFolder, "encrypted"),
+		notifier:   notifier,
+		states:     states,
+		throttle:   throttle,
+		schedule:   schedule,
+	}
 
-	filenames, err := dir.Readdirnames(0)
+	go u.runQueue()
+
+	return u, nil
+}
+
+// RunLogUploads uploads rotated logs now and then every configured interval,
+// skipping runs outside of the upload windows.
+func (u *Uploader) RunLogUploads() {
+	for {
+		if u.schedule.Open(time.Now()) {
+			u.UploadLogs()
+		}
+
+		time.Sleep(config.GetConfig().Logs.UploadInterval)
+	}
+}
+
+// UploadLogs uploads the compressed, rotated logs. The file currently written to
+// is never uploaded, nor are logs that are still being compressed.
+func (u *Uploader) UploadLogs() {
+	logFolder := config.GetConfig().LogFolder
+
+	entries, err := os.ReadDir(logFolder)
 
 	if err != nil {
 		u.logger.LogError(err, "Error reading log folder", "folder", logFolder)
 		return
 	}
 
-	deviceHostName, err := os.Hostname()
+	active := filepath.Base(u.logger.ActiveFile())
+	var filenames []string
 
-	if err != nil {
-		u.logger.LogError(err, "Error getting device hostname", "function", "UploadLogs")
-		return
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Name() != active && strings.HasSuffix(entry.Name(), logger.CompressedExt) {
+			filenames = append(filenames, entry.Name())
+		}
 	}
 
-	sort.Strings(filenames)
+	if len(filenames) == 0 {
+		return
+	}
 
-	filenames = filenames[:len(filenames)-1] // remove last file, which is the current log file
+	u.logger.LogInfo("Uploading logs", "backend", config.GetConfig().StoreConfig.Backend, "folder", logFolder, "count", fmt.Sprint(len(filenames)))
 
 	for _, filename := range filenames {
 		localFilename := fmt.Sprintf("%s/%s", logFolder, filename)
-		f, err := os.ReadFile(localFilename)
+		info, err := inspectFile(localFilename, filename, "logs")
 
 		if err != nil {
-			u.logger.LogError(err, "Error reading log file", "filename", localFilename)
+			u.logger.LogError(err, "Error reading log file", "filename", filename)
 			continue
 		}
 
-		_, err = u.uploader.Upload(&s3manager.UploadInput{
-			Bucket:      aws.String(s3config.Bucket),
-			Key:         aws.String(fmt.Sprintf("%s/logs/%s", deviceHostName, filename)),
-			Body:        aws.ReadSeekCloser(bytes.NewReader(f)),
-			ContentType: aws.String("text/plain"),
+		metadata, tags := objectMetadata(info)
+		_, err = u.putFile(localFilename, PutInput{
+			Key:         objectKey(info),
+			ContentType: "application/gzip",
+			Metadata:    metadata,
+			Tags:        tags,
 		})
 
 		if err != nil {
@@ -115,203 +186,332 @@ func (u *Uploader) UploadLogs() {
 	}
 }
 
-func (u *Uploader) UploadRecording(filename string) error {
-	if u.videoIsRecording {
+// UploadRecording uploads a single recording straight away. Outside of the upload
+// windows the file is queued for the next window instead, unless ignoreWindow is set,
+// and the queue item is returned.
+func (u *Uploader) UploadRecording(filename string, ignoreWindow bool) (*models.DeferredUpload, error) {
+	if u.videoIsRecording.Load() {
 		u.logger.LogError(errors.New("recording in progress"), "Cannot upload recording while recording is in progress")
 		err := apperror.ServiceUnavailable
 		err = err.SetMessage("Cannot upload recording while recording is in progress")
-		return err
+		return nil, err
 	}
 
-	if u.isUploading {
-		u.logger.LogError(errors.New("upload in progress"), "Cannot upload recording while another upload is in progress")
-		err := apperror.ServiceUnavailable
-		err = err.SetMessage("Cannot upload recording while another upload is in progress")
-		return err
+	if _, _, _, ok := recordingTarget(filename); !ok {
+		u.logger.LogError(errors.New("unsupported file type"), "Only .avi and .wav recordings can be uploaded", "file_name", filename)
+		return nil, apperror.InvalidRequest.SetMessage("Only .avi and .wav recordings can be uploaded")
 	}
 
-	u.isUploading = true
-	u.uploadName = fmt.Sprintf("%s.avi", filename)
-	defer func() {
-		u.isUploading = false
-		u.uploadName = ""
-	}()
+	if !ignoreWindow && !u.schedule.Open(time.Now()) {
+		return u.deferToWindow(filename)
+	}
 
-	videosFolder := config.GetConfig().VideosFolder
-	_, err := os.Stat(fmt.Sprintf("%s/%s", videosFolder, filename))
+	u.slots <- struct{}{} // waits for a worker when the queue or UploadRecordings use them all
+	defer func() { <-u.slots }()
 
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			u.logger.LogError(err, "Provided file does not exist in specified folder", "folder_name", videosFolder, "file_name", filename)
-			return apperror.NotFound
-		}
-		u.logger.LogError(err, "Error reading file", "folder_name", videosFolder, "file_name", filename)
-		return apperror.ServerError
+	t, ok := u.begin(filename)
+
+	if !ok {
+		u.logger.LogError(errors.New("upload in progress"), "Cannot upload recording that is already being uploaded", "file_name", filename)
+		err := apperror.ServiceUnavailable
+		err = err.SetMessage("This recording is already being uploaded")
+		return nil, err
 	}
+	defer u.end(filename)
 
-	deviceHostName, err := os.Hostname()
+	err := u.upload(t)
 
 	if err != nil {
-		u.logger.LogError(err, "Error fetching device hostname", "action", "upload", "file_name", filename)
-		return apperror.ServerError
+		var appErr apperror.Apperror
+		if errors.As(err, &appErr) {
+			return nil, err
+		}
+		u.retryLater(filename, err)
+		return nil, apperror.ServerError
 	}
 
-	s3Config := config.GetConfig().S3Config
-	f := fmt.Sprintf("%s/%s", videosFolder, filename)
-	fd, err := os.ReadFile(f)
+	return nil, nil
+}
 
-	if err != nil {
-		u.logger.LogError(err, "Error reading file", "folder_name", videosFolder, "file_name", filename)
-		return apperror.ServerError
+// UploadRecordings uploads every recording that isn't uploaded yet. Outside of the
+// upload windows they are queued for the next window instead, and their queue items
+// are returned.
+func (u *Uploader) UploadRecordings() (*models.DeferredUpload, error) {
+	if u.videoIsRecording.Load() {
+		u.logger.LogError(errors.New("recording in progress"), "Cannot upload recording while recording is in progress")
+		err := apperror.ServiceUnavailable
+		err = err.SetMessage("Cannot upload recording while recording is in progress")
+		return nil, err
 	}
 
-	_, err = u.uploader.Upload(&s3manager.UploadInput{
-		Bucket:      aws.String(s3Config.Bucket),
-		Key:         aws.String(fmt.Sprintf("%s/videos/%s", deviceHostName, filename)),
-		ACL:         aws.String("private"),
-		Body:        bytes.NewReader(fd),
-		ContentType: aws.String("video/x-msvideo"),
-	})
+	files, err := helper.FetchFiles()
 
 	if err != nil {
-		u.logger.LogError(err, "Error uploading file to S3", "folder_name", videosFolder, "file_name", filename)
-		return apperror.ServerError
+		u.logger.LogError(err, "Error fetching files", "function", "UploadAllRecording")
+		return nil, apperror.ServerError
 	}
 
-	u.logger.LogInfo("Successful upload to S3", "folder_name", videosFolder, "file_name", filename)
+	if !u.schedule.Open(time.Now()) {
+		var pending []string
 
-	if err = performCallBack(filename); err != nil {
-		u.logger.LogError(err, "Error performing callback", "folder_name", videosFolder, "file_name", filename)
+		for _, file := range files {
+			if _, _, _, ok := recordingTarget(file); ok && !u.IsUploaded(file) {
+				pending = append(pending, file)
+			}
+		}
+		return u.deferToWindow(pending...)
 	}
 
-	if err = os.Remove(f); err != nil {
-		u.logger.LogError(err, "Error deleting file", "folder_name", videosFolder, "file_name", filename)
-		return apperror.ServerError
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < cap(u.slots); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				u.slots <- struct{}{} // the queue uploads with the same workers
+				t, ok := u.begin(file)
+
+				if !ok {
+					<-u.slots
+					continue // already being uploaded by the queue or a manual request
+				}
+
+				err := u.upload(t)
+
+				switch {
+				case err == nil, errors.Is(err, apperror.NotFound):
+				case errors.Is(err, apperror.ServiceUnavailable):
+					u.Enqueue(file) // written to again, the queue uploads it once that's done
+				default:
+					u.retryLater(file, err)
+				}
+				u.end(file)
+				<-u.slots
+			}
+		}()
 	}
 
-	u.logger.LogInfo("Successful deletion of file", "folder_name", videosFolder, "file_name", filename)
+	for _, file := range files {
+		// voice activated clips can be written to while nothing is being recorded
+		if _, _, _, ok := recordingTarget(file); ok && !u.IsUploaded(file) && !u.beingWritten(file) {
+			jobs <- file
+		}
+	}
 
-	return nil
+	close(jobs)
+	wg.Wait()
+
+	return nil, nil
 }
 
-func (u *Uploader) UploadRecordings() error {
-	if u.videoIsRecording {
-		u.logger.LogError(errors.New("recording in progress"), "Cannot upload recording while recording is in progress")
-		err := apperror.ServiceUnavailable
-		err = err.SetMessage("Cannot upload recording while recording is in progress")
-		return err
+// upload sends a single recording to the store and removes the local copy afterwards.
+func (u *Uploader) upload(t *transfer) error {
+	filename := t.filename
+	folder, contentType, kind, ok := recordingTarget(filename)
+
+	if !ok {
+		return apperror.InvalidRequest.SetMessage("Only .avi and .wav recordings can be uploaded")
 	}
 
-	if u.isUploading {
-		u.logger.LogError(errors.New("upload in progress"), "Cannot upload recording while another upload is in progress")
-		err := apperror.ServiceUnavailable
-		err = err.SetMessage("Cannot upload recording while another upload is in progress")
-		return err
+	if u.beingWritten(filename) {
+		return apperror.ServiceUnavailable.SetMessage("Cannot upload a recording that is still being written")
 	}
 
-	u.isUploading = true
-	defer func() {
-		u.isUploading = false
-		u.uploadName = ""
-	}()
-	videosFolder := config.GetConfig().VideosFolder
-	audiosFolder := config.GetConfig().AudiosFolder
-	files, err := helper.FetchFiles()
+	f := fmt.Sprintf("%s/%s", folder, filename)
+	info, err := inspectFile(f, filename, kind)
 
 	if err != nil {
-		u.logger.LogError(err, "Error fetching files", "function", "UploadAllRecording")
-		return apperror.ServerError
+		if errors.Is(err, os.ErrNotExist) {
+			u.logger.LogError(err, "Provided file does not exist in specified folder", "folder_name", folder, "file_name", filename)
+			_ = u.queue.Remove(filename)
+			_ = u.states.Remove(filename)
+			u.removeManifest(filename)
+			return apperror.NotFound
+		}
+		u.logger.LogError(err, "Error reading file", "folder_name", folder, "file_name", filename)
+		return err
 	}
 
-	deviceHostName, err := os.Hostname()
+	key := objectKey(info)
+	metadata, tags := objectMetadata(info)
+	source := f
 
-	if err != nil {
-		u.logger.LogError(err, "Error fetching device hostname", "function", "UploadAllRecording")
-		return apperror.ServerError
+	if err = u.states.Uploading(filename, key); err != nil {
+		u.logger.LogError(err, "Error saving upload state", "file_name", filename)
 	}
 
-	s3Config := config.GetConfig().S3Config
-	var (
-		ext            string
-		f              string
-		contentType    string
-		remoteFileName string
-	)
+	u.logger.LogInfo("Uploading file", "file_name", filename, "key", key)
 
-	for _, file := range files {
-		ext = file[len(file)-4:]
-		if ext != ".avi" && ext != ".wav" {
-			continue
+	if u.encrypter != nil {
+		var encryption map[string]string
+		source, encryption, err = u.encrypt(f, filename, contentType)
+
+		if err != nil {
+			u.logger.LogError(err, "Error encrypting file", "folder_name", folder, "file_name", filename)
+			return err
 		}
 
-		u.uploadName = fmt.Sprintf("%s.%s", file, ext)
-		u.logger.LogInfo("Uploading file to S3", "file_name", file)
-
-		switch ext {
-		case ".avi":
-			f = fmt.Sprintf("%s/%s", videosFolder, file)
-			contentType = "video/x-msvideo"
-			remoteFileName = fmt.Sprintf("%s/videos/%s", deviceHostName, file)
-		case ".wav":
-			f = fmt.Sprintf("%s/%s", audiosFolder, file)
-			contentType = "audio/x-wav"
-			remoteFileName = fmt.Sprintf("%s/audios/%s", deviceHostName, file)
+		for name, value := range encryption {
+			metadata[name] = value
 		}
+		contentType = "application/octet-stream"
+	}
 
-		contents, err := os.ReadFile(f)
+	stat, err := os.Stat(source)
 
-		if err != nil {
-			u.logger.LogError(err, "Error reading file", "folder_name", videosFolder, "file_name", file)
-			return apperror.ServerError
-		}
+	if err != nil {
+		u.logger.LogError(err, "Error reading file", "folder_name", folder, "file_name", filename)
+		return err
+	}
 
-		_, err = u.uploader.Upload(&s3manager.UploadInput{
-			Bucket:      aws.String(s3Config.Bucket),
-			Key:         aws.String(remoteFileName),
-			ACL:         aws.String("private"),
-			Body:        bytes.NewReader(contents),
-			ContentType: aws.String(contentType),
-		})
+	t.start(key, stat.Size())
+	t.setPhase(phaseUploading)
 
-		if err != nil {
-			u.logger.LogError(err, "Error uploading file to S3", "folder_name", videosFolder, "file_name", file)
-			continue
+	// the local copy is only removed once the stored object has been verified
+	object, err := u.putFile(source, PutInput{
+		Key:         key,
+		ContentType: contentType,
+		Metadata:    metadata,
+		Tags:        tags,
+		Progress:    t.add,
+	})
+
+	if err != nil {
+		u.logger.LogError(err, "Error uploading file", "folder_name", folder, "file_name", filename)
+		if sErr := u.states.Failed(filename, err); sErr != nil {
+			u.logger.LogError(sErr, "Error saving upload state", "file_name", filename)
 		}
+		return err
+	}
 
-		u.logger.LogInfo("Successful upload to S3", "folder_name", videosFolder, "file_name", file)
+	if u.encrypter != nil {
+		u.removeStaging(filename)
+	}
 
-		if err = performCallBack(file); err != nil {
-			u.logger.LogError(err, "Error performing callback", "folder_name", videosFolder, "file_name", file)
+	if err = u.uploadManifest(info, key, object); err != nil {
+		u.logger.LogError(err, "Error uploading recording manifest", "folder_name", folder, "file_name", filename)
+		if sErr := u.states.Failed(filename, err); sErr != nil {
+			u.logger.LogError(sErr, "Error saving upload state", "file_name", filename)
 		}
+		return err
+	}
+
+	u.removeManifest(filename)
 
-		if err = os.Remove(f); err != nil {
-			u.logger.LogError(err, "Error deleting file", "folder_name", videosFolder, "file_name", file)
+	if local, err := os.Stat(f); err == nil {
+		err = u.states.Uploaded(filename, key, local)
+
+		if err != nil {
+			u.logger.LogError(err, "Error saving upload state", "file_name", filename)
 		}
+	}
 
-		u.logger.LogInfo("Successful deletion of file", "folder_name", videosFolder, "file_name", file)
+	u.logger.LogInfo("Successful upload", "folder_name", folder, "file_name", filename)
+
+	if err = u.queue.Remove(filename); err != nil {
+		u.logger.LogError(err, "Error updating upload queue", "file_name", filename)
 	}
 
+	if u.notifier != nil {
+		u.notifier.Notify(models.WebhookPayload{
+			Event:    webhook.EventUploadCompleted,
+			Filename: filename,
+			Key:      key,
+			Size:     object.Size,
+			Checksum: object.Metadata[checksumKey],
+			Duration: info.Duration.Seconds(),
+			DeviceID: config.GetConfig().DeviceID,
+		})
+	}
+
+	if config.GetConfig().StoreConfig.KeepLocal {
+		u.uploaded(filename, key, false)
+		return nil // the retention manager deletes the local copy later
+	}
+
+	if err = os.Remove(f); err != nil {
+		u.logger.LogError(err, "Error deleting file", "folder_name", folder, "file_name", filename)
+		u.uploaded(filename, key, false)
+		return apperror.ServerError
+	}
+
+	_ = u.states.Remove(filename)
+	u.logger.LogInfo("Successful deletion of file", "folder_name", folder, "file_name", filename)
+	u.uploaded(filename, key, true)
+
 	return nil
 }
 
+// OnUploaded registers a function called after every successful upload of a
+// recording, deleted tells whether the local copy has been deleted.
+func (u *Uploader) OnUploaded(handler func(filename, key string, deleted bool)) {
+	u.onUploaded = handler
+}
+
+func (u *Uploader) uploaded(filename, key string, deleted bool) {
+	if u.onUploaded != nil {
+		u.onUploaded(filename, key, deleted)
+	}
+}
+
+// CheckWriting registers a function telling whether a recorder is still writing to
+// a file. Such files are never uploaded, as they would be deleted afterwards.
+func (u *Uploader) CheckWriting(isWriting func(filename string) bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.isWriting = isWriting
+}
+
+func (u *Uploader) beingWritten(filename string) bool {
+	u.lock.Lock()
+	isWriting := u.isWriting
+	u.lock.Unlock()
+
+	return isWriting != nil && isWriting(filename)
+}
+
+// Forget drops everything the uploader keeps about a recording whose local copy
+// has been deleted without being uploaded.
+func (u *Uploader) Forget(filename string) {
+	_ = u.queue.Remove(filename)
+	_ = u.states.Remove(filename)
+	u.removeManifest(filename)
+}
+
 func (u *Uploader) InformRecordingStart() {
-	u.videoIsRecording = true
+	u.videoIsRecording.Store(true)
 }
 
 func (u *Uploader) InformRecordingStop() {
-	u.videoIsRecording = false
+	u.videoIsRecording.Store(false)
 }
 
-func performCallBack(filename string) error {
-	resp, err := http.Get(fmt.Sprintf("https://videos-service.herokuapp.com/%s", filename))
+// localExists reports whether a recording is still on disk.
+func localExists(filename string) bool {
+	folder, _, _, ok := recordingTarget(filename)
 
-	if err != nil {
-		return err
+	if !ok {
+		return false
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return errors.New("received non 200 status code")
+	_, err := os.Stat(fmt.Sprintf("%s/%s", folder, filename))
+	return err == nil
+}
+
+// recordingTarget returns the local folder, content type and remote key segment for a recording.
+func recordingTarget(filename string) (folder, contentType, kind string, ok bool) {
+	if filepath.Base(filename) != filename || strings.HasPrefix(filename, ".") {
+		return "", "", "", false // never leave the recordings folders
 	}
 
-	return nil
+	switch filepath.Ext(filename) {
+	case ".avi":
+		return config.GetConfig().VideosFolder, "video/x-msvideo", "videos", true
+	case ".wav":
+		return config.GetConfig().AudiosFolder, "audio/x-wav", "audios", true
+	default:
+		return "", "", "", false
+	}
 }
@@ -0,0 +1,160 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// checksumKey is the metadata key the SHA-256 of every uploaded file is stored under.
+const checksumKey = "sha256"
+
+// errChecksumMismatch is returned by Store.Verify when the stored object differs from the local content.
+var errChecksumMismatch = errors.New("stored object does not match the local content")
+
+// checksums reads the file once to compute its SHA-256 and MD5, then rewinds it.
+func checksums(file *os.File) ([]byte, []byte, error) {
+	sha := sha256.New()
+	md := md5.New()
+
+	if _, err := io.Copy(io.MultiWriter(sha, md), file); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	return sha.Sum(nil), md.Sum(nil), nil
+}
+
+// hashContent returns the hex SHA-256 of everything read from r.
+func hashContent(r io.Reader) (string, error) {
+	sha := sha256.New()
+
+	if _, err := io.Copy(sha, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sha.Sum(nil)), nil
+}
+
+// compositeChecksum hashes content in parts of partSize and returns the hash of the
+// concatenated part hashes, the way S3 checksums multipart uploads, with the part count.
+func compositeChecksum(newHash func() hash.Hash, content io.ReaderAt, size int64, partSize int64) ([]byte, int64, error) {
+	combined := newHash()
+	var parts int64
+
+	for offset := int64(0); offset < size; offset += partSize {
+		part := newHash()
+
+		if _, err := io.Copy(part, io.NewSectionReader(content, offset, partSize)); err != nil {
+			return nil, 0, err
+		}
+
+		combined.Write(part.Sum(nil))
+		parts++
+	}
+
+	return combined.Sum(nil), parts, nil
+}
+
+// putFile uploads a local file along with its checksum and only returns once the
+// stored object has been checked to have the same size and checksum. The body and
+// checksums of input are filled in from the file.
+func (u *Uploader) putFile(path string, input PutInput) (*Object, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sha, md, err := checksums(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := hex.EncodeToString(sha)
+
+	metadata := map[string]string{checksumKey: checksum}
+	for key, value := range input.Metadata {
+		metadata[key] = value
+	}
+
+	input.Body = file
+	input.ContentMD5 = base64.StdEncoding.EncodeToString(md)
+	input.ChecksumSHA256 = base64.StdEncoding.EncodeToString(sha)
+	input.Metadata = metadata
+
+	if err = u.store.Put(&input); err != nil {
+		return nil, err
+	}
+
+	return u.verify(input.Key, checksum, file, info.Size())
+}
+
+// putBytes uploads data like putFile does a local file.
+func (u *Uploader) putBytes(data []byte, input PutInput) (*Object, error) {
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data)
+	checksum := hex.EncodeToString(sha[:])
+
+	metadata := map[string]string{checksumKey: checksum}
+	for key, value := range input.Metadata {
+		metadata[key] = value
+	}
+
+	input.Body = bytes.NewReader(data)
+	input.ContentMD5 = base64.StdEncoding.EncodeToString(md[:])
+	input.ChecksumSHA256 = base64.StdEncoding.EncodeToString(sha[:])
+	input.Metadata = metadata
+
+	if err := u.store.Put(&input); err != nil {
+		return nil, err
+	}
+
+	return u.verify(input.Key, checksum, bytes.NewReader(data), int64(len(data)))
+}
+
+// verify checks the stored object against the local content. The checksum compared
+// is computed by the backend from what it stored, never read back from the metadata
+// set by the upload, so only a verified object allows the local file to be deleted.
+func (u *Uploader) verify(key string, checksum string, content io.ReaderAt, size int64) (*Object, error) {
+	object, err := u.store.Head(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("verifying upload: %w", err)
+	}
+
+	if object.Size != size {
+		err = fmt.Errorf("integrity check failed for %s", key)
+		u.logger.LogError(err, "Uploaded object does not match local file", "key", key,
+			"local_size", fmt.Sprint(size), "remote_size", fmt.Sprint(object.Size))
+		return nil, err
+	}
+
+	if err = u.store.Verify(key, checksum, content, size); err != nil {
+		err = fmt.Errorf("integrity check failed for %s: %w", key, err)
+		u.logger.LogError(err, "Uploaded object does not match local file", "key", key, "local_sha256", checksum)
+		return nil, err
+	}
+
+	u.logger.LogInfo("Verified uploaded object", "key", key, "sha256", checksum)
+	return object, nil
+}
@@ -0,0 +1,217 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pirecorder/apperror"
+	"pirecorder/config"
+	"pirecorder/models"
+	"time"
+)
+
+// settleDelay gives the recorders time to finish writing before a new recording is uploaded.
+const settleDelay = 5 * time.Second
+
+// Enqueue adds a finished recording to the upload queue.
+func (u *Uploader) Enqueue(filename string) {
+	if _, _, _, ok := recordingTarget(filename); !ok {
+		return
+	}
+
+	if err := u.queue.Add(filename, settleDelay); err != nil {
+		u.logger.LogError(err, "Error adding file to upload queue", "file_name", filename)
+		return
+	}
+
+	if err := u.states.Pending(filename); err != nil {
+		u.logger.LogError(err, "Error saving upload state", "file_name", filename)
+	}
+
+	u.logger.LogInfo("Queued file for upload", "file_name", filename)
+}
+
+func (u *Uploader) QueueItems() []models.QueueItem {
+	return u.queue.Items()
+}
+
+// WebhookDeliveries returns the webhook delivery log, newest first.
+func (u *Uploader) WebhookDeliveries() []models.WebhookDelivery {
+	if u.notifier == nil {
+		return []models.WebhookDelivery{}
+	}
+	return u.notifier.Deliveries()
+}
+
+// UploadWindow reports whether background uploads may run now and when the next window opens.
+func (u *Uploader) UploadWindow() (bool, time.Time) {
+	now := time.Now()
+	return u.schedule.Open(now), u.schedule.NextOpen(now)
+}
+
+// Throughput returns the current upload rate in bytes per second.
+func (u *Uploader) Throughput() float64 {
+	return u.throttle.Throughput()
+}
+
+// deferToWindow queues files for the next upload window and returns their queue items.
+func (u *Uploader) deferToWindow(filenames ...string) (*models.DeferredUpload, error) {
+	queued := make(map[string]bool, len(filenames))
+
+	for _, filename := range filenames {
+		if err := u.queue.Add(filename, 0); err != nil {
+			u.logger.LogError(err, "Error adding file to upload queue", "file_name", filename)
+			return nil, apperror.ServerError
+		}
+
+		if err := u.states.Pending(filename); err != nil {
+			u.logger.LogError(err, "Error saving upload state", "file_name", filename)
+		}
+
+		queued[filename] = true
+		u.logger.LogInfo("Outside of upload window, queued file", "file_name", filename)
+	}
+
+	deferred := &models.DeferredUpload{
+		Queued:           []models.QueueItem{},
+		NextUploadWindow: u.schedule.NextOpen(time.Now()),
+	}
+
+	for _, item := range u.queue.Items() {
+		if queued[item.Filename] {
+			deferred.Queued = append(deferred.Queued, item)
+		}
+	}
+
+	return deferred, nil
+}
+
+// IsUploaded reports whether this exact recording has been uploaded, i.e. it hasn't
+// changed since its upload was verified.
+func (u *Uploader) IsUploaded(filename string) bool {
+	_, ok := u.UploadedAt(filename)
+	return ok
+}
+
+// UploadedAt returns when a recording was uploaded, if it has been and hasn't changed since.
+func (u *Uploader) UploadedAt(filename string) (time.Time, bool) {
+	state, ok := u.states.Get(filename)
+
+	if !ok || state.Status != stateUploaded || state.UploadedAt == nil {
+		return time.Time{}, false
+	}
+
+	folder, _, _, _ := recordingTarget(filename)
+	info, err := os.Stat(fmt.Sprintf("%s/%s", folder, filename))
+
+	if err != nil || info.Size() != state.Size || !info.ModTime().Equal(state.ModTime) {
+		return time.Time{}, false
+	}
+
+	return *state.UploadedAt, true
+}
+
+// UploadState returns the upload state of a recording, nil if it was never queued or uploaded.
+func (u *Uploader) UploadState(filename string) *models.UploadState {
+	state, ok := u.states.Get(filename)
+
+	if !ok {
+		return nil
+	}
+
+	return &state
+}
+
+func (u *Uploader) retryLater(filename string, err error) {
+	storeConfig := config.GetConfig().StoreConfig
+
+	if qErr := u.queue.Add(filename, storeConfig.RetryBase); qErr != nil {
+		u.logger.LogError(qErr, "Error adding file to upload queue", "file_name", filename)
+		return
+	}
+
+	if qErr := u.queue.Failed(filename, err, storeConfig.RetryBase, storeConfig.RetryMax); qErr != nil {
+		u.logger.LogError(qErr, "Error updating upload queue", "file_name", filename)
+	}
+}
+
+// runQueue uploads queued files in the background as they become due, with up to
+// the configured number of uploads running at once, UploadRecordings included.
+func (u *Uploader) runQueue() {
+	for {
+		item := u.nextDue()
+		u.slots <- struct{}{} // wait for a free worker
+		t, ok := u.begin(item.Filename)
+
+		if !ok {
+			<-u.slots
+			continue
+		}
+
+		go func() {
+			defer func() { <-u.slots }()
+			defer u.end(t.filename)
+			u.uploadQueued(t)
+		}()
+	}
+}
+
+// nextDue blocks until a queued file that isn't already being uploaded or written is due,
+// an upload window is open and nothing is being recorded.
+func (u *Uploader) nextDue() *models.QueueItem {
+	for {
+		if now := time.Now(); !u.schedule.Open(now) {
+			select {
+			case <-u.queue.Wake():
+			case <-time.After(time.Until(u.schedule.NextOpen(now))):
+			}
+			continue
+		}
+
+		writing := false
+		item, wait := u.queue.Next(func(filename string) bool {
+			if u.isActive(filename) {
+				return true
+			}
+
+			written := u.beingWritten(filename)
+			writing = writing || written
+			return written
+		})
+
+		if item != nil && !u.videoIsRecording.Load() {
+			return item
+		}
+
+		// files being written are checked again soon rather than when they are due
+		if item != nil || (writing && wait > settleDelay) {
+			wait = settleDelay
+		}
+
+		select {
+		case <-u.queue.Wake():
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (u *Uploader) uploadQueued(t *transfer) {
+	storeConfig := config.GetConfig().StoreConfig
+	err := u.upload(t)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, apperror.NotFound), errors.Is(err, apperror.InvalidRequest):
+		u.logger.LogWarning(err, "Dropping file from upload queue", "file_name", t.filename)
+		_ = u.queue.Remove(t.filename)
+	case errors.Is(err, apperror.ServiceUnavailable):
+		// still being written, that's no failed attempt
+		if qErr := u.queue.Postpone(t.filename, settleDelay); qErr != nil {
+			u.logger.LogError(qErr, "Error updating upload queue", "file_name", t.filename)
+		}
+	default:
+		if qErr := u.queue.Failed(t.filename, err, storeConfig.RetryBase, storeConfig.RetryMax); qErr != nil {
+			u.logger.LogError(qErr, "Error updating upload queue", "file_name", t.filename)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package upload
+
+import (
+	"fmt"
+	"path/filepath"
+	"pirecorder/logger"
+	"testing"
+	"time"
+)
+
+func TestUploadRecordingOutsideWindowIsQueued(t *testing.T) {
+	// a window that opens in an hour, so uploads are closed now
+	now := time.Now()
+	start := (now.Hour()*60 + now.Minute() + 60) % (24 * 60)
+	end := (start + 60) % (24 * 60)
+
+	schedule, err := ParseSchedule(fmt.Sprintf("%02d:%02d-%02d:%02d", start/60, start%60, end/60, end%60))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := newTestUploader(t, schedule)
+
+	deferred, err := u.UploadRecording("clip.avi", false)
+
+	if err != nil {
+		t.Fatalf("UploadRecording outside the window = %v, want it queued", err)
+	}
+
+	if deferred == nil || len(deferred.Queued) != 1 || deferred.Queued[0].Filename != "clip.avi" {
+		t.Fatalf("UploadRecording outside the window = %+v, want clip.avi queued", deferred)
+	}
+
+	if !deferred.NextUploadWindow.After(now) || deferred.NextUploadWindow.After(now.Add(time.Hour)) {
+		t.Errorf("next upload window = %v, want within the next hour", deferred.NextUploadWindow)
+	}
+
+	if state, ok := u.states.Get("clip.avi"); !ok || state.Status != statePending {
+		t.Errorf("upload state = %+v, want pending", state)
+	}
+}
+
+func TestQueuedFileStillBeingWrittenIsNoFailure(t *testing.T) {
+	u := newTestUploader(t, &Schedule{})
+	u.CheckWriting(func(string) bool { return true })
+
+	if err := u.queue.Add("clip.wav", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, _ := u.begin("clip.wav")
+	u.uploadQueued(transfer)
+	u.end("clip.wav")
+
+	items := u.queue.Items()
+
+	if len(items) != 1 {
+		t.Fatalf("queue = %+v, want clip.wav still queued", items)
+	}
+
+	if items[0].Attempts != 0 || items[0].LastError != "" {
+		t.Errorf("queue item = %+v, want no failed attempt", items[0])
+	}
+
+	if wait := time.Until(items[0].NextRetry); wait <= 0 || wait > settleDelay {
+		t.Errorf("queue item is due in %v, want within %v", wait, settleDelay)
+	}
+}
+
+func newTestUploader(t *testing.T, schedule *Schedule) *Uploader {
+	t.Helper()
+
+	queue, err := NewQueue(filepath.Join(t.TempDir(), "upload-queue.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := NewStates(filepath.Join(t.TempDir(), "upload-state.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Uploader{
+		active:   make(map[string]*transfer),
+		slots:    make(chan struct{}, 1),
+		logger:   logger.NewDiscardLogger(),
+		queue:    queue,
+		states:   states,
+		schedule: schedule,
+	}
+}
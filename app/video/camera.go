@@ -8,15 +8,29 @@ import (
 	"os/exec"
 	"pirecorder/config"
 	"pirecorder/logger"
+	"pirecorder/models"
+	"sync/atomic"
 	"time"
 
 	"github.com/icza/mjpeg"
 )
 
+// recordings are written at the size and rate the camera is started with
+const (
+	frameWidth  = 640
+	frameHeight = 480
+	frameRate   = 30
+)
+
 type Camera struct {
-	isRecording bool
+	isRecording bool // stays true until the recording is closed, the AVI index is written last
 	isCamUp     bool
+	videoClose  chan struct{} // closed to stop the current recording
+	videoDone   chan struct{} // closed once the current recording is closed
 	recordName  string
+	firstFrame  atomic.Pointer[time.Time] // set by the recorder, nil until the first frame is written
+	frames      atomic.Int64              // frames written to the current recording
+	dropped     atomic.Int64              // frames of the current recording that couldn't be written
 	mux         *Mux
 	logger      *logger.Logger
 }
@@ -84,6 +98,27 @@ func (c *Camera) RecordingStats() (bool, string) {
 	return c.isRecording, c.recordName
 }
 
+// FirstFrameTime returns when the first frame of the current recording was written,
+// it is zero until then.
+func (c *Camera) FirstFrameTime() time.Time {
+	if first := c.firstFrame.Load(); first != nil {
+		return *first
+	}
+	return time.Time{}
+}
+
+// RecordingInfo describes the video of the current or last recording.
+func (c *Camera) RecordingInfo() models.VideoInfo {
+	return models.VideoInfo{
+		Width:         frameWidth,
+		Height:        frameHeight,
+		FPS:           frameRate,
+		Codec:         "mjpeg",
+		Frames:        c.frames.Load(),
+		DroppedFrames: c.dropped.Load(),
+	}
+}
+
 func (c *Camera) StartStream() (chan []byte, chan struct{}, error) {
 	if !c.isCamUp {
 		return nil, nil, fmt.Errorf("camera is not up")
@@ -124,10 +159,10 @@ func (c *Camera) StartRecording(filename string) error {
 	}
 
 	if c.isRecording {
-		c.isRecording = false
+		<-c.stopRecorder()
 	}
 
-	aw, err := mjpeg.New(fmt.Sprintf("%s/%s.avi", config.GetConfig().VideosFolder, filename), 640, 480, 30)
+	aw, err := mjpeg.New(fmt.Sprintf("%s/%s.avi", config.GetConfig().VideosFolder, filename), frameWidth, frameHeight, frameRate)
 
 	if err != nil {
 		c.logger.LogError(err, "Error creating video file", "filename", filename)
@@ -136,21 +171,36 @@ func (c *Camera) StartRecording(filename string) error {
 
 	c.isRecording = true
 	c.recordName = fmt.Sprintf("%s.avi", filename)
+	c.firstFrame.Store(nil)
+	c.frames.Store(0)
+	c.dropped.Store(0)
+	c.videoClose = make(chan struct{})
+	c.videoDone = make(chan struct{})
 
-	go func() {
+	go func(stop, done chan struct{}) {
 		defer func() {
-			_ = aw.Close()
+			if err := aw.Close(); err != nil {
+				c.logger.LogError(err, "Error closing video file", "filename", filename)
+			}
 			c.isRecording = false
 			c.recordName = ""
+			close(done)
 		}()
 
 		var previousFrame []byte
-		ticker := time.Tick(33000 * time.Microsecond) // 30 fps
+		ticker := time.NewTicker(33000 * time.Microsecond) // 30 fps
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
 
-		for c.isRecording {
-			<-ticker
 			frame := c.mux.GetFrame()
 
+			// no new frame yet, the ticker and the camera aren't in step so that's no loss
 			if len(frame) == 0 || bytes.Equal(frame, previousFrame) {
 				continue
 			}
@@ -159,10 +209,17 @@ func (c *Camera) StartRecording(filename string) error {
 
 			if err != nil {
 				c.logger.LogError(err, "Error adding frame to video file", "filename", filename)
+				c.dropped.Add(1)
+			} else {
+				if c.firstFrame.Load() == nil {
+					first := time.Now()
+					c.firstFrame.Store(&first)
+				}
+				c.frames.Add(1)
 			}
 			previousFrame = frame
 		}
-	}()
+	}(c.videoClose, c.videoDone)
 
 	return nil
 }
@@ -170,6 +227,17 @@ func (c *Camera) StartRecording(filename string) error {
 func (c *Camera) StopRecording() {
 	c.logger.LogInfo("Stopping video recording", "filename", c.recordName)
 	if c.isRecording {
-		c.isRecording = false
+		c.stopRecorder()
+	}
+}
+
+// stopRecorder asks the current recording to stop, it may already have been asked
+// while its file is being closed. The returned channel is closed once it is.
+func (c *Camera) stopRecorder() chan struct{} {
+	select {
+	case <-c.videoClose:
+	default:
+		close(c.videoClose)
 	}
+	return c.videoDone
 }
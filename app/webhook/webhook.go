@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pirecorder/app/helper"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"pirecorder/models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests carry the delivery ID, a unix timestamp and an HMAC-SHA256 signature of
+// "<timestamp>.<body>" keyed with the configured secret, hex encoded with a
+// "sha256=" prefix. Receivers should check the signature with VerifySignature and
+// use the delivery ID to ignore retries they have already processed.
+const (
+	HeaderDelivery  = "X-PiRecorder-Delivery"
+	HeaderTimestamp = "X-PiRecorder-Timestamp"
+	HeaderSignature = "X-PiRecorder-Signature"
+
+	EventUploadCompleted = "upload.completed"
+
+	statusPending   = "pending"
+	statusDelivered = "delivered"
+	statusFailed    = "failed"
+
+	// finished deliveries kept in the log, pending ones are always kept
+	logSize = 200
+)
+
+// Notifier delivers upload notifications in the background and keeps a durable
+// delivery log, so notifications that are still pending survive restarts.
+type Notifier struct {
+	lock       sync.Mutex
+	conf       config.Webhook
+	client     *http.Client
+	path       string
+	deliveries []*models.WebhookDelivery
+	wake       chan struct{}
+	logger     *logger.Logger
+}
+
+// NewNotifier returns nil when no webhook URL is configured. Every request is
+// signed with the secret, config.Load makes sure there is one.
+func NewNotifier(logger *logger.Logger, conf config.Webhook, path string) (*Notifier, error) {
+	if conf.URL == "" {
+		return nil, nil
+	}
+
+	n := &Notifier{
+		conf:   conf,
+		client: &http.Client{Timeout: conf.Timeout},
+		path:   path,
+		wake:   make(chan struct{}, 1),
+		logger: logger,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err == nil {
+		if err = json.Unmarshal(data, &n.deliveries); err != nil {
+			return nil, err
+		}
+	}
+
+	go n.run()
+
+	return n, nil
+}
+
+// Notify queues a notification for delivery.
+func (n *Notifier) Notify(payload models.WebhookPayload) {
+	id, err := newID()
+
+	if err != nil {
+		n.logger.LogError(err, "Error generating webhook delivery id")
+		return
+	}
+
+	now := time.Now()
+	payload.Timestamp = now.UTC()
+
+	n.lock.Lock()
+	n.deliveries = append(n.deliveries, &models.WebhookDelivery{
+		ID:        id,
+		Status:    statusPending,
+		Payload:   payload,
+		CreatedAt: now,
+		NextRetry: now,
+	})
+	err = n.save()
+	n.lock.Unlock()
+
+	if err != nil {
+		n.logger.LogError(err, "Error saving webhook delivery log")
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliveries returns the delivery log, newest first.
+func (n *Notifier) Deliveries() []models.WebhookDelivery {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	deliveries := make([]models.WebhookDelivery, 0, len(n.deliveries))
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *n.deliveries[i])
+	}
+	return deliveries
+}
+
+func (n *Notifier) run() {
+	for {
+		delivery, wait := n.next()
+
+		if delivery == nil {
+			select {
+			case <-n.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		statusCode, err := n.send(delivery)
+		n.finish(delivery.ID, statusCode, err)
+	}
+}
+
+// next returns a copy of the pending delivery due soonest, or how long to wait for one.
+func (n *Notifier) next() (*models.WebhookDelivery, time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var next *models.WebhookDelivery
+
+	for _, delivery := range n.deliveries {
+		if delivery.Status == statusPending && (next == nil || delivery.NextRetry.Before(next.NextRetry)) {
+			next = delivery
+		}
+	}
+
+	if next == nil {
+		return nil, time.Hour
+	}
+
+	if wait := time.Until(next.NextRetry); wait > 0 {
+		return nil, wait
+	}
+
+	delivery := *next
+	return &delivery, 0
+}
+
+func (n *Notifier) send(delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(n.conf.Method, n.conf.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for name, value := range n.conf.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(n.conf.Secret, timestamp, body))
+
+	resp, err := n.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (n *Notifier) finish(id string, statusCode int, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, delivery := range n.deliveries {
+		if delivery.ID != id {
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode = statusCode
+
+		switch {
+		case err == nil:
+			now := time.Now()
+			delivery.Status = statusDelivered
+			delivery.LastError = ""
+			delivery.DeliveredAt = &now
+			n.logger.LogInfo("Delivered webhook", "id", id, "file_name", delivery.Payload.Filename)
+		case delivery.Attempts >= n.conf.MaxAttempts:
+			delivery.Status = statusFailed
+			delivery.LastError = err.Error()
+			n.logger.LogError(err, "Giving up on webhook delivery", "id", id, "file_name", delivery.Payload.Filename,
+				"attempts", strconv.Itoa(delivery.Attempts))
+		default:
+			delivery.LastError = err.Error()
+			delivery.NextRetry = time.Now().Add(helper.Backoff(delivery.Attempts, n.conf.RetryBase, n.conf.RetryMax))
+			n.logger.LogWarning(err, "Webhook delivery failed, retrying", "id", id, "file_name", delivery.Payload.Filename,
+				"next_retry", delivery.NextRetry.Format(time.RFC3339))
+		}
+		break
+	}
+
+	n.trim()
+
+	if err := n.save(); err != nil {
+		n.logger.LogError(err, "Error saving webhook delivery log")
+	}
+}
+
+// trim drops the oldest finished deliveries beyond logSize, it must be called with the lock held.
+func (n *Notifier) trim() {
+	finished := 0
+	for _, delivery := range n.deliveries {
+		if delivery.Status != statusPending {
+			finished++
+		}
+	}
+
+	kept := n.deliveries[:0]
+	for _, delivery := range n.deliveries {
+		if delivery.Status != statusPending && finished > logSize {
+			finished--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	n.deliveries = kept
+}
+
+// save must be called with the lock held.
+func (n *Notifier) save() error {
+	data, err := json.MarshalIndent(n.deliveries, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := n.path + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, n.path)
+}
+
+// Sign returns the signature header value for a request body sent at timestamp.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature and rejects requests signed more than tolerance ago.
+func VerifySignature(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return errors.New("timestamp outside of tolerance")
+	}
+
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func newID() (string, error) {
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pirecorder/config"
+	"pirecorder/logger"
+	"pirecorder/models"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cr3t"
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	body := []byte(`{"event":"upload.completed"}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	if err := VerifySignature(testSecret, timestamp, body, Sign(testSecret, timestamp, body), time.Minute); err != nil {
+		t.Errorf("VerifySignature of a signed body = %v, want nil", err)
+	}
+}
+
+func TestVerifySignatureRejects(t *testing.T) {
+	body := []byte(`{"event":"upload.completed","size":1}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		signature string
+	}{
+		{"tampered body", testSecret, now, []byte(`{"event":"upload.completed","size":2}`), Sign(testSecret, now, body)},
+		{"wrong secret", "other", now, body, Sign(testSecret, now, body)},
+		{"stale timestamp", testSecret, stale, body, Sign(testSecret, stale, body)},
+		{"future timestamp", testSecret, future, body, Sign(testSecret, future, body)},
+		{"timestamp changed after signing", testSecret, now, body, Sign(testSecret, stale, body)},
+		{"invalid timestamp", testSecret, "yesterday", body, Sign(testSecret, "yesterday", body)},
+		{"missing signature", testSecret, now, body, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := VerifySignature(test.secret, test.timestamp, test.body, test.signature, 5*time.Minute); err == nil {
+				t.Error("VerifySignature succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestNotifierDeliversAndRetries(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		lock.Lock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		attempt := len(requests)
+		lock.Unlock()
+
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	path := filepath.Join(t.TempDir(), "deliveries.json")
+
+	n, err := NewNotifier(logger.NewDiscardLogger(), config.Webhook{
+		URL:         server.URL,
+		Method:      http.MethodPost,
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		Secret:      testSecret,
+		Timeout:     time.Second,
+		RetryBase:   10 * time.Millisecond,
+		RetryMax:    10 * time.Millisecond,
+		MaxAttempts: 3,
+	}, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(models.WebhookPayload{Event: EventUploadCompleted, Filename: "clip.avi", Key: "device/videos/clip.avi", Size: 42})
+
+	delivery := waitForDelivery(t, n)
+
+	if delivery.Status != statusDelivered || delivery.Attempts != 2 || delivery.StatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %+v, want delivered on the second attempt", delivery)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(requests) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(requests))
+	}
+
+	for i, r := range requests {
+		err = VerifySignature(testSecret, r.Header.Get(HeaderTimestamp), bodies[i], r.Header.Get(HeaderSignature), time.Minute)
+
+		if err != nil {
+			t.Errorf("request %d: %v", i+1, err)
+		}
+
+		if r.Header.Get(HeaderDelivery) != delivery.ID {
+			t.Errorf("request %d delivery id = %q, want %q", i+1, r.Header.Get(HeaderDelivery), delivery.ID)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("request %d is missing the configured headers", i+1)
+		}
+	}
+
+	var payload models.WebhookPayload
+
+	if err = json.Unmarshal(bodies[1], &payload); err != nil || payload.Filename != "clip.avi" || payload.Size != 42 {
+		t.Errorf("payload = %+v, %v, want the notified upload", payload, err)
+	}
+
+	// the log survives a restart
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved []models.WebhookDelivery
+
+	if err = json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].Status != statusDelivered {
+		t.Errorf("saved delivery log = %s, %v, want the delivered notification", data, err)
+	}
+}
+
+func waitForDelivery(t *testing.T, n *Notifier) models.WebhookDelivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if deliveries := n.Deliveries(); len(deliveries) == 1 && deliveries[0].Status != statusPending {
+			return deliveries[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("notification was not delivered in time")
+	return models.WebhookDelivery{}
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -28,7 +31,27 @@ func Load() {
 		LogFolder:    os.Getenv("LOG_FOLDER"),
 		VideosFolder: os.Getenv("VIDEOS_FOLDER"),
 		AudiosFolder: os.Getenv("AUDIOS_FOLDER"),
-		Environment:  os.Getenv("PIRECORDER_ENVIRONMENT"),
+		DataFolder: func() string {
+			folder := os.Getenv("DATA_FOLDER")
+			if folder == "" {
+				return "data"
+			}
+			return folder
+		}(),
+		Environment: os.Getenv("PIRECORDER_ENVIRONMENT"),
+		AudioOnly:   os.Getenv("AUDIO_ONLY") == "true",
+		SiteID:      os.Getenv("SITE_ID"),
+		CameraID:    getEnvString("CAMERA_ID", "camera0"),
+		DeviceID: func() string {
+			if id := os.Getenv("DEVICE_ID"); id != "" {
+				return id
+			}
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Println("Error getting device hostname:", err)
+			}
+			return hostname
+		}(),
 		S3Config: S3{
 			Bucket:      os.Getenv("S3_BUCKET_NAME"),
 			AccessKey:   os.Getenv("S3_ACCESS_KEY"),
@@ -36,10 +59,74 @@ func Load() {
 			Region:      os.Getenv("S3_REGION"),
 			EndpointUrl: os.Getenv("S3_ENDPOINT_URL"),
 		},
+		StoreConfig: Store{
+			Backend:                  os.Getenv("STORAGE_BACKEND"),
+			LocalPath:                os.Getenv("LOCAL_STORE_PATH"),
+			PartSize:                 int64(getEnvInt("UPLOAD_PART_SIZE_MB", 8)) * 1024 * 1024,
+			Concurrency:              getEnvInt("UPLOAD_CONCURRENCY", 2),
+			Workers:                  getEnvInt("UPLOAD_WORKERS", 2),
+			KeyTemplate:              getEnvString("UPLOAD_KEY_TEMPLATE", "{device}/{kind}/{name}"),
+			MultipartMaxAge:          getEnvDuration("UPLOAD_MULTIPART_MAX_AGE", 7*24*time.Hour),
+			MultipartCleanupInterval: getEnvDuration("UPLOAD_MULTIPART_CLEANUP_INTERVAL", 6*time.Hour),
+			AutoUpload:               os.Getenv("AUTO_UPLOAD") != "false",
+			RetryBase:                getEnvDuration("UPLOAD_RETRY_BASE", 30*time.Second),
+			RetryMax:                 getEnvDuration("UPLOAD_RETRY_MAX", time.Hour),
+			RateLimit:                int64(getEnvInt("UPLOAD_RATE_LIMIT_KB", 0)) * 1024,
+			Windows:                  os.Getenv("UPLOAD_WINDOWS"),
+			KeepLocal:                os.Getenv("UPLOAD_KEEP_LOCAL") == "true",
+			SFTP: SFTP{
+				Host:                  os.Getenv("SFTP_HOST"),
+				User:                  os.Getenv("SFTP_USER"),
+				Password:              os.Getenv("SFTP_PASSWORD"),
+				KeyFile:               os.Getenv("SFTP_KEY_FILE"),
+				KnownHosts:            os.Getenv("SFTP_KNOWN_HOSTS"),
+				Path:                  os.Getenv("SFTP_PATH"),
+				InsecureIgnoreHostKey: os.Getenv("SFTP_INSECURE_IGNORE_HOST_KEY") == "true",
+			},
+		},
 		SSLConfig: SSL{
 			CertFile: os.Getenv("SSL_CERT_FILE"),
 			KeyFile:  os.Getenv("SSL_KEY_FILE"),
 		},
+		Encryption: Encryption{
+			PublicKeyFile: os.Getenv("ENCRYPTION_PUBLIC_KEY"),
+			KeyFile:       os.Getenv("ENCRYPTION_KEY_FILE"),
+		},
+		Webhook: Webhook{
+			URL:         os.Getenv("WEBHOOK_URL"),
+			Method:      strings.ToUpper(getEnvString("WEBHOOK_METHOD", "POST")),
+			Headers:     getEnvHeaders("WEBHOOK_HEADERS"),
+			Secret:      os.Getenv("WEBHOOK_SECRET"),
+			Timeout:     getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			RetryBase:   getEnvDuration("WEBHOOK_RETRY_BASE", 10*time.Second),
+			RetryMax:    getEnvDuration("WEBHOOK_RETRY_MAX", 30*time.Minute),
+			MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		},
+		Retention: Retention{
+			MaxAge:          getEnvDuration("RETENTION_MAX_AGE", 0),
+			MaxBytes:        int64(getEnvFloat("RETENTION_MAX_GB", 0) * 1024 * 1024 * 1024),
+			MinFreePercent:  getEnvFloat("RETENTION_MIN_FREE_PERCENT", 0),
+			Force:           os.Getenv("RETENTION_FORCE") == "true",
+			KeepUploadedFor: getEnvDuration("RETENTION_KEEP_UPLOADED_FOR", 0),
+			KeepDeletedFor:  getEnvLimitDuration("RETENTION_KEEP_DELETED_FOR", 90*24*time.Hour),
+			Interval:        getEnvDuration("RETENTION_INTERVAL", 10*time.Minute),
+		},
+		Logs: Logs{
+			MaxSize:        int64(getEnvLimitInt("LOG_MAX_SIZE_MB", 10)) * 1024 * 1024,
+			MaxAge:         getEnvLimitDuration("LOG_MAX_AGE", 24*time.Hour),
+			UploadInterval: getEnvDuration("LOG_UPLOAD_INTERVAL", time.Hour),
+		},
+		AudioConfig: Audio{
+			Source:            os.Getenv("AUDIO_SOURCE"),
+			ReconnectInterval: getEnvDuration("AUDIO_RECONNECT_INTERVAL", 5*time.Second),
+			LevelWindow:       getEnvDuration("AUDIO_LEVEL_WINDOW", 100*time.Millisecond),
+			SilenceThreshold:  getEnvFloat("AUDIO_SILENCE_THRESHOLD_DB", -50),
+			SilenceTimeout:    getEnvDuration("AUDIO_SILENCE_TIMEOUT", 30*time.Second),
+			VADEnabled:        os.Getenv("AUDIO_VAD_ENABLED") == "true",
+			VADThreshold:      getEnvFloat("AUDIO_VAD_THRESHOLD_DB", -35),
+			VADHangTime:       getEnvDuration("AUDIO_VAD_HANG_TIME", 2*time.Second),
+			VADPreRoll:        getEnvDuration("AUDIO_VAD_PRE_ROLL", 500*time.Millisecond),
+		},
 		Port: func() string {
 			port := os.Getenv("PORT")
 			if port == "" {
@@ -48,8 +135,81 @@ func Load() {
 			return port
 		}(),
 	}
+
+	// unsigned notifications could be forged by anyone who can reach the receiver
+	if Conf.Webhook.URL != "" && Conf.Webhook.Secret == "" {
+		log.Fatal("WEBHOOK_SECRET must be set when WEBHOOK_URL is")
+	}
 }
 
 func GetConfig() Config {
 	return Conf
 }
+
+func getEnvString(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvLimitInt is getEnvInt for limits, where an explicit 0 turns the limit off.
+func getEnvLimitInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvLimitDuration is getEnvDuration for limits, where an explicit 0 turns the limit off.
+func getEnvLimitDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvHeaders parses "Name: value" pairs separated by semicolons.
+func getEnvHeaders(key string) map[string]string {
+	headers := make(map[string]string)
+
+	for _, pair := range strings.Split(os.Getenv(key), ";") {
+		name, value, found := strings.Cut(pair, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
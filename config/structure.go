@@ -1,13 +1,26 @@
 package config
 
+import "time"
+
 type Config struct {
 	Environment  string
 	LogFolder    string
 	VideosFolder string
 	AudiosFolder string
+	DataFolder   string
 	Port         string
+	AudioOnly    bool
+	SiteID       string
+	DeviceID     string
+	CameraID     string
 	S3Config     S3
+	StoreConfig  Store
 	SSLConfig    SSL
+	AudioConfig  Audio
+	Encryption   Encryption
+	Webhook      Webhook
+	Retention    Retention
+	Logs         Logs
 }
 
 type S3 struct {
@@ -18,7 +31,90 @@ type S3 struct {
 	EndpointUrl string
 }
 
+type Store struct {
+	Backend     string // s3, local or sftp
+	LocalPath   string
+	PartSize    int64 // bytes
+	Concurrency int   // parts in flight per file
+	Workers     int   // files uploaded at once
+	// KeyTemplate builds object keys, see app/upload/key.go for the placeholders
+	KeyTemplate string
+	// MultipartMaxAge is how long an unfinished multipart upload is kept around to be resumed
+	MultipartMaxAge time.Duration
+	// MultipartCleanupInterval is how often unfinished multipart uploads are checked against MultipartMaxAge
+	MultipartCleanupInterval time.Duration
+	AutoUpload               bool
+	RetryBase                time.Duration
+	RetryMax                 time.Duration
+	RateLimit                int64  // bytes per second, 0 for unlimited
+	Windows                  string // time-of-day windows for background uploads, e.g. 22:00-06:00
+	KeepLocal                bool   // keep recordings after upload, the retention manager deletes them later
+	SFTP                     SFTP
+}
+
+type SFTP struct {
+	Host       string
+	User       string
+	Password   string
+	KeyFile    string
+	KnownHosts string
+	Path       string
+	// InsecureIgnoreHostKey accepts any host key when KnownHosts is not set, for testing only
+	InsecureIgnoreHostKey bool
+}
+
+// Encryption is enabled when either key is set, the public key takes precedence
+type Encryption struct {
+	PublicKeyFile string // PEM encoded RSA public key
+	KeyFile       string // 32 byte key, raw, hex or base64
+}
+
+// Webhook is called after every verified upload, it is disabled when URL is empty
+type Webhook struct {
+	URL         string
+	Method      string
+	Headers     map[string]string
+	Secret      string // HMAC-SHA256 key for the signature header
+	Timeout     time.Duration
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+	MaxAttempts int
+}
+
+// Retention limits how much of the local disk recordings may use, zero values disable a limit
+type Retention struct {
+	MaxAge         time.Duration
+	MaxBytes       int64
+	MinFreePercent float64
+	// Force allows deleting recordings that haven't been uploaded when the limits can't be met otherwise
+	Force bool
+	// KeepUploadedFor deletes uploaded recordings this long after their upload, 0 keeps them until another limit applies
+	KeepUploadedFor time.Duration
+	// KeepDeletedFor is how long recordings deleted without being uploaded stay in the catalog, 0 keeps them
+	KeepDeletedFor time.Duration
+	Interval       time.Duration
+}
+
+// Logs are rotated once they reach MaxSize bytes or MaxAge, zero disables a limit
+type Logs struct {
+	MaxSize        int64
+	MaxAge         time.Duration
+	UploadInterval time.Duration // how often rotated logs are uploaded
+}
+
 type SSL struct {
 	CertFile string
 	KeyFile  string
 }
+
+type Audio struct {
+	Source            string
+	ReconnectInterval time.Duration
+	LevelWindow       time.Duration
+	SilenceThreshold  float64 // dBFS
+	SilenceTimeout    time.Duration
+	VADEnabled        bool
+	VADThreshold      float64 // dBFS
+	VADHangTime       time.Duration
+	VADPreRoll        time.Duration
+}
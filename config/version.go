@@ -0,0 +1,4 @@
+package config
+
+// Version is set at build time with -ldflags "-X pirecorder/config.Version=<version>".
+var Version = "dev"
@@ -8,8 +8,14 @@ require (
 	github.com/icza/mjpeg v0.0.0-20220812133530-f79265a232f2
 	github.com/jfreymuth/pulse v0.1.0
 	github.com/joho/godotenv v1.5.1
+	github.com/pkg/sftp v1.13.5
 	github.com/sirupsen/logrus v1.9.0
-	golang.org/x/sys v0.1.0
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.0.0-20211215153901-e495a2d5b3d3
+	golang.org/x/sys v0.4.0
 )
 
-require github.com/jmespath/go-jmespath v0.4.0 // indirect
+require (
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+)
@@ -2,27 +2,20 @@ package logger
 
 import (
 	"github.com/sirupsen/logrus"
+	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"time"
 )
 
 type Logger struct {
 	logger *logrus.Logger
+	output *rotatingFile
 }
 
-func NewLogger(filename string) (*Logger, error) {
-	dirname := filepath.Dir(filename)
-	
-	_, err := os.Stat(dirname)
-
-	if err != nil {
-		err = os.MkdirAll(dirname, 0755)
-		if err != nil {
-			return nil, err
-		}
-	}
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0755)
+// NewLogger logs to files in folder, rotated once they reach maxSize bytes or
+// maxAge, zero disables either limit.
+func NewLogger(folder string, maxSize int64, maxAge time.Duration) (*Logger, error) {
+	output, err := newRotatingFile(folder, maxSize, maxAge)
 	if err != nil {
 		return nil, err
 	}
@@ -30,13 +23,30 @@ func NewLogger(filename string) (*Logger, error) {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	//logger.SetReportCaller(true)
-	logger.SetOutput(file)
+	logger.SetOutput(output)
 
 	return &Logger{
 		logger: logger,
+		output: output,
 	}, nil
 }
 
+// NewDiscardLogger returns a logger that writes nowhere, for tests.
+func NewDiscardLogger() *Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &Logger{logger: logger}
+}
+
+// ActiveFile returns the path of the log file currently written to, it must not be uploaded.
+func (l *Logger) ActiveFile() string {
+	if l.output == nil {
+		return ""
+	}
+	return l.output.Active()
+}
+
 // [a, b, c, d, e, f]
 
 func convertToFields(values []any) (fields logrus.Fields) {
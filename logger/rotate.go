@@ -0,0 +1,202 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logPrefix     = "pirecorder_logs_"
+	logExt        = ".log"
+	CompressedExt = ".log.gz"
+)
+
+// rotatingFile is the log output. It starts a new file once the current one reaches
+// maxSize bytes or is older than maxAge, finished files are gzip compressed.
+type rotatingFile struct {
+	lock     sync.Mutex
+	folder   string
+	maxSize  int64
+	maxAge   time.Duration
+	file     *os.File
+	path     string
+	size     int64
+	openedAt time.Time
+}
+
+func newRotatingFile(folder string, maxSize int64, maxAge time.Duration) (*rotatingFile, error) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &rotatingFile{
+		folder:  folder,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	// logs left uncompressed by a crash or an older version are finished too
+	r.compressLeftovers()
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// keep logging to the current file rather than losing entries
+			log.Println("error rotating log file:", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Active returns the path of the file currently written to.
+func (r *rotatingFile) Active() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.path
+}
+
+func (r *rotatingFile) due(next int64) bool {
+	if r.size == 0 {
+		return false
+	}
+
+	if r.maxSize > 0 && r.size+next > r.maxSize {
+		return true
+	}
+
+	return r.maxAge > 0 && time.Since(r.openedAt) > r.maxAge
+}
+
+// open must be called with the lock held.
+func (r *rotatingFile) open() error {
+	now := time.Now()
+	path := filepath.Join(r.folder, fmt.Sprintf("%s%s%s", logPrefix, now.Format("2006-01-02_15:04:05.000"), logExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	r.file = file
+	r.path = path
+	r.size = stat.Size()
+	r.openedAt = now
+	return nil
+}
+
+// rotate must be called with the lock held.
+func (r *rotatingFile) rotate() error {
+	previous, previousPath := r.file, r.path
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if err := previous.Close(); err != nil {
+		log.Println("error closing log file:", err)
+	}
+
+	go compress(previousPath)
+	return nil
+}
+
+func (r *rotatingFile) compressLeftovers() {
+	entries, err := os.ReadDir(r.folder)
+
+	if err != nil {
+		log.Println("error reading log folder:", err)
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(r.folder, entry.Name())
+
+		switch {
+		case entry.IsDir() || path == r.path:
+		case strings.HasSuffix(entry.Name(), CompressedExt+".tmp"):
+			_ = os.Remove(path) // its source is still there and gets compressed again
+		case strings.HasSuffix(entry.Name(), logExt):
+			go compress(path)
+		}
+	}
+}
+
+// compress replaces path with path.gz. The compressed file only appears once it is
+// complete, so a crash never leaves a truncated archive behind.
+func compress(path string) {
+	if err := compressFile(path); err != nil {
+		log.Println("error compressing log file:", err)
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = src.Close() }()
+
+	target := strings.TrimSuffix(path, logExt) + CompressedExt
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+
+	if err == nil {
+		err = dst.Sync()
+	}
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, target); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"pirecorder/app"
+	"pirecorder/app/envelope"
+	"pirecorder/app/webhook"
 	"pirecorder/config"
 	"pirecorder/logger"
 	"pirecorder/web/controller"
@@ -15,10 +19,20 @@ import (
 )
 
 func main() {
-	config.Load()
-	logfile := fmt.Sprintf("pirecorder_logs_%s.log", time.Now().Format("2006-01-02_15:04:05"))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "decrypt":
+			decrypt(os.Args[2:])
+			return
+		case "webhook-receiver":
+			receiveWebhooks(os.Args[2:])
+			return
+		}
+	}
 
-	logman, err := logger.NewLogger(fmt.Sprintf("%s/%s", config.GetConfig().LogFolder, logfile))
+	config.Load()
+	logConf := config.GetConfig().Logs
+	logman, err := logger.NewLogger(config.GetConfig().LogFolder, logConf.MaxSize, logConf.MaxAge)
 
 	if err != nil {
 		log.Fatal(err)
@@ -28,6 +42,7 @@ func main() {
 
 	if err != nil {
 		logman.LogError(err, "Error creating app")
+		log.Fatal(err)
 	}
 
 	ctrl := controller.NewController(svc, logman)
@@ -65,3 +80,83 @@ func main() {
 		}
 	}
 }
+
+// decrypt restores a recording downloaded from the store, using the private key
+// matching ENCRYPTION_PUBLIC_KEY or the same file as ENCRYPTION_KEY_FILE.
+func decrypt(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyPath := flags.String("key", "", "RSA private key (PEM) or key file used for encryption")
+	in := flags.String("in", "", "encrypted file")
+	out := flags.String("out", "", "where to write the decrypted file")
+	_ = flags.Parse(args)
+
+	if *keyPath == "" || *in == "" || *out == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src, err := os.Open(*in)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer func() { _ = src.Close() }()
+
+	dst, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = envelope.Decrypt(src, dst, *keyPath)
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(*out)
+		log.Fatal(err)
+	}
+}
+
+// receiveWebhooks runs a receiver that checks webhook signatures and prints every
+// delivery, for testing the webhook configuration locally.
+func receiveWebhooks(args []string) {
+	flags := flag.NewFlagSet("webhook-receiver", flag.ExitOnError)
+	addr := flags.String("addr", ":9090", "address to listen on")
+	secret := flags.String("secret", "", "the WEBHOOK_SECRET of the recorder, required")
+	tolerance := flags.Duration("tolerance", 5*time.Minute, "maximum age of a signed request")
+	_ = flags.Parse(args)
+
+	if *secret == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		delivery := r.Header.Get(webhook.HeaderDelivery)
+		err = webhook.VerifySignature(*secret, r.Header.Get(webhook.HeaderTimestamp), body,
+			r.Header.Get(webhook.HeaderSignature), *tolerance)
+
+		if err != nil {
+			log.Printf("rejected delivery %s: %v", delivery, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("%s %s delivery %s: %s", r.Method, r.URL.Path, delivery, body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Listening for webhooks on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
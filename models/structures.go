@@ -1,14 +1,207 @@
 package models
 
+import "time"
+
 type Status struct {
-	CameraUp  bool    `json:"isCamUp"`
-	Recording bool    `json:"isRecording"`
-	Uploading bool    `json:"isUploading"`
-	DiskUsage float32 `json:"diskUsage"`
+	CameraUp         bool         `json:"isCamUp"`
+	Mode             string       `json:"mode"`
+	Recording        bool         `json:"isRecording"`
+	UploaderUp       bool         `json:"isUploaderUp"`
+	Uploading        bool         `json:"isUploading"`
+	UploadThroughput float64      `json:"uploadThroughput"` // bytes per second
+	UploadWindowOpen bool         `json:"isUploadWindowOpen"`
+	NextUploadWindow *time.Time   `json:"nextUploadWindow,omitempty"`
+	DiskUsage        float32      `json:"diskUsage"`
+	MicUp            bool         `json:"isMicUp"`
+	Listening        bool         `json:"isListening"`
+	Audio            *AudioLevels `json:"audioLevels,omitempty"`
+}
+
+type StartRecordingRequest struct {
+	Filename  string            `json:"filename"` // defaults to the recording ID
+	AudioOnly bool              `json:"audioOnly"`
+	Label     string            `json:"label"`
+	Tags      []string          `json:"tags"`
+	Metadata  map[string]string `json:"metadata"`
 }
 
 type FileDetails struct {
-	Filename  string `json:"filename"`
-	Uploading bool   `json:"isUploading"`
-	Recording bool   `json:"isRecording"`
+	Filename    string          `json:"filename"`
+	RecordingID string          `json:"recordingId,omitempty"`
+	Uploading   bool            `json:"isUploading"`
+	Recording   bool            `json:"isRecording"`
+	Progress    *UploadProgress `json:"progress,omitempty"`
+	Upload      *UploadState    `json:"upload,omitempty"`
+}
+
+// Recording is a single recording session, the audio and video files it produced
+// are its attributes.
+type Recording struct {
+	ID              string            `json:"id"`
+	Label           string            `json:"label,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Mode            string            `json:"mode"`
+	Status          string            `json:"status"` // recording, stopped, uploading, uploaded or deleted
+	StartedAt       time.Time         `json:"startedAt"`
+	StoppedAt       *time.Time        `json:"stoppedAt,omitempty"`
+	DurationSeconds float64           `json:"durationSeconds,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Adopted         bool              `json:"adopted,omitempty"` // found on disk rather than started through the API
+	Files           []RecordingFile   `json:"files"`
+}
+
+type RecordingFile struct {
+	Filename        string       `json:"filename"`
+	Kind            string       `json:"kind"` // videos or audios
+	Size            int64        `json:"size"`
+	DurationSeconds float64      `json:"durationSeconds"`
+	Key             string       `json:"key,omitempty"`
+	UploadedAt      *time.Time   `json:"uploadedAt,omitempty"`
+	DeletedAt       *time.Time   `json:"deletedAt,omitempty"` // when the local copy was deleted
+	Missing         bool         `json:"missing,omitempty"`   // the local copy disappeared without being deleted
+	Local           *FileDetails `json:"local,omitempty"`     // live details while the file is on disk, only set in responses
+}
+
+// RecordingFilter selects and orders recordings in list requests, zero values match everything.
+type RecordingFilter struct {
+	Status string
+	Mode   string
+	Tag    string
+	Label  string // case-insensitive substring
+	From   time.Time
+	To     time.Time
+	Sort   string // startedAt, duration, size or label
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+type UploadState struct {
+	Status     string     `json:"status"` // pending, uploading, uploaded or failed
+	Key        string     `json:"key,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	UploadedAt *time.Time `json:"uploadedAt,omitempty"`
+	Size       int64      `json:"size"`    // of the local file when it was uploaded
+	ModTime    time.Time  `json:"modTime"` // of the local file when it was uploaded
+}
+
+type UploadProgress struct {
+	Filename       string    `json:"filename"`
+	Key            string    `json:"key,omitempty"`
+	Phase          string    `json:"phase"` // preparing or uploading
+	BytesSent      int64     `json:"bytesSent"`
+	TotalBytes     int64     `json:"totalBytes"`
+	Percent        float64   `json:"percent"`
+	BytesPerSecond float64   `json:"bytesPerSecond"`
+	ETASeconds     *float64  `json:"etaSeconds,omitempty"`
+	StartedAt      time.Time `json:"startedAt"`
+}
+
+type AudioLevels struct {
+	RMS            float64 `json:"rms"`
+	Peak           float64 `json:"peak"`
+	RMSDb          float64 `json:"rmsDb"`
+	PeakDb         float64 `json:"peakDb"`
+	ClippedSamples int     `json:"clippedSamples"`
+	TotalClipped   int     `json:"totalClipped"`
+	Silent         bool    `json:"isSilent"`
+}
+
+type AudioDevice struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Channels    int    `json:"channels"`
+	SampleRate  int    `json:"sampleRate"`
+	Selected    bool   `json:"isSelected"`
+}
+
+type SyncInfo struct {
+	Name                 string    `json:"name"`
+	VideoFile            string    `json:"videoFile"`
+	AudioFile            string    `json:"audioFile"`
+	StartedAt            time.Time `json:"startedAt"`
+	VideoFirstFrameMs    *float64  `json:"videoFirstFrameOffsetMs"`
+	AudioFirstSampleMs   *float64  `json:"audioFirstSampleOffsetMs"`
+	AudioToVideoOffsetMs *float64  `json:"audioToVideoOffsetMs"`
+}
+
+type QueueItem struct {
+	Filename   string    `json:"filename"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"lastError,omitempty"`
+	NextRetry  time.Time `json:"nextRetry"`
+}
+
+// DeferredUpload is returned when uploads requested outside of the upload window were queued instead.
+type DeferredUpload struct {
+	Queued           []QueueItem `json:"queued"`
+	NextUploadWindow time.Time   `json:"nextUploadWindow"`
+	Recording        *Recording  `json:"recording,omitempty"`
+}
+
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Filename  string    `json:"filename"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"sha256"`
+	Duration  float64   `json:"durationSeconds"`
+	DeviceID  string    `json:"deviceId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type WebhookDelivery struct {
+	ID          string         `json:"id"`
+	Status      string         `json:"status"` // pending, delivered or failed
+	Payload     WebhookPayload `json:"payload"`
+	Attempts    int            `json:"attempts"`
+	StatusCode  int            `json:"statusCode,omitempty"`
+	LastError   string         `json:"lastError,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	NextRetry   time.Time      `json:"nextRetry"`
+	DeliveredAt *time.Time     `json:"deliveredAt,omitempty"`
+}
+
+// RecordingManifest is uploaded as <filename>.json next to every recording so
+// processors don't need to probe the media file.
+type RecordingManifest struct {
+	RecordingID     string            `json:"recordingId,omitempty"`
+	Label           string            `json:"label,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Filename        string            `json:"filename"`
+	Kind            string            `json:"kind"`
+	Key             string            `json:"key,omitempty"`
+	Size            int64             `json:"size,omitempty"`
+	Checksum        string            `json:"sha256,omitempty"` // of the uploaded object, i.e. the ciphertext when encrypted
+	Encrypted       bool              `json:"encrypted"`
+	StartedAt       time.Time         `json:"startedAt"`
+	StoppedAt       time.Time         `json:"stoppedAt"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Video           *VideoInfo        `json:"video,omitempty"`
+	Audio           *AudioInfo        `json:"audio,omitempty"`
+	Hostname        string            `json:"hostname"`
+	DeviceID        string            `json:"deviceId"`
+	SiteID          string            `json:"siteId,omitempty"`
+	CameraID        string            `json:"cameraId,omitempty"`
+	AppVersion      string            `json:"appVersion"`
+	Request         map[string]string `json:"request,omitempty"` // metadata sent with the request that started the recording
+	Sync            *SyncInfo         `json:"sync,omitempty"`
+}
+
+type VideoInfo struct {
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	FPS           int    `json:"fps"`
+	Codec         string `json:"codec"`
+	Frames        int64  `json:"frames"`
+	DroppedFrames int64  `json:"droppedFrames"`
+}
+
+type AudioInfo struct {
+	Format        string `json:"format"`
+	SampleRate    int    `json:"sampleRate"`
+	BitsPerSample int    `json:"bitsPerSample"`
+	Channels      int    `json:"channels"`
 }
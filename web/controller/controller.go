@@ -2,14 +2,24 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"os"
+	"path/filepath"
 	"pirecorder/app"
 	"pirecorder/apperror"
 	"pirecorder/logger"
+	"pirecorder/models"
 	"pirecorder/web/helper"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
 )
 
 type Controller struct {
@@ -56,10 +66,85 @@ func (c *Controller) ShowStream(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+func (c *Controller) ListenStream(w http.ResponseWriter, r *http.Request) {
+	chunks, closeChan, contentType, err := c.app.StartAudioStream(r.URL.Query().Get("format"))
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+	defer close(closeChan)
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-chunks:
+			if !ok {
+				return
+			}
+
+			if _, err = w.Write(chunk); err != nil {
+				c.logger.LogError(err, "Error writing audio chunk")
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (c *Controller) ShowAudioLevels(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		helper.ReturnFailure(w, apperror.ServerError.SetMessage("Streaming not supported"))
+		return
+	}
+
+	levels, closeChan, err := c.app.StartLevelStream()
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+	defer close(closeChan)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case level := <-levels:
+			data, err := json.Marshal(level)
+
+			if err != nil {
+				c.logger.LogError(err, "Error encoding audio levels")
+				continue
+			}
+
+			if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				c.logger.LogError(err, "Error writing audio levels")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
 func (c *Controller) StartRecording(w http.ResponseWriter, r *http.Request) {
-	p := struct {
-		Filename string `json:"filename"`
-	}{}
+	var p models.StartRecordingRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		c.logger.LogError(err, "Error getting filename for recording from request")
@@ -67,18 +152,237 @@ func (c *Controller) StartRecording(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := c.app.StartRecording(p.Filename); err != nil {
+	recording, err := c.app.StartRecording(p)
+
+	if err != nil {
 		c.logger.LogError(err, "Error starting recording", "filename", p.Filename)
 		helper.ReturnFailure(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	helper.ReturnSuccess(w, recording)
 }
 
 func (c *Controller) StopRecording(w http.ResponseWriter, _ *http.Request) {
-	c.app.StopRecording()
+	recording := c.app.StopRecording()
 	c.logger.LogInfo("stopping recording")
+
+	if recording == nil {
+		helper.ReturnSuccess(w, nil)
+		return
+	}
+
+	helper.ReturnSuccess(w, recording)
+}
+
+func (c *Controller) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	c.logger.LogInfo("list recordings request received")
+
+	filter, err := recordingFilter(r)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	recordings, err := c.app.ListRecordings(filter)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	helper.ReturnSuccess(w, recordings)
+}
+
+// recordingFilter reads the status, mode, tag, label, from, to, sort, order, limit
+// and offset query parameters of list requests.
+func recordingFilter(r *http.Request) (models.RecordingFilter, error) {
+	query := r.URL.Query()
+	filter := models.RecordingFilter{
+		Status: query.Get("status"),
+		Mode:   query.Get("mode"),
+		Tag:    query.Get("tag"),
+		Label:  query.Get("label"),
+		Sort:   query.Get("sort"),
+	}
+
+	switch filter.Sort {
+	case "", "startedAt":
+		filter.Desc = query.Get("order") != "asc" // newest first by default
+	case "duration", "size", "label":
+		filter.Desc = query.Get("order") == "desc"
+	default:
+		return filter, apperror.InvalidRequest.SetMessage("sort must be one of startedAt, duration, size or label")
+	}
+
+	for name, value := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := query.Get(name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+
+			if err != nil {
+				return filter, apperror.InvalidRequest.SetMessage(fmt.Sprintf("%s must be an RFC 3339 time", name))
+			}
+			*value = parsed
+		}
+	}
+
+	for name, value := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if raw := query.Get(name); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+
+			if err != nil || parsed < 0 {
+				return filter, apperror.InvalidRequest.SetMessage(fmt.Sprintf("%s must be a positive number", name))
+			}
+			*value = parsed
+		}
+	}
+
+	return filter, nil
+}
+
+func (c *Controller) GetRecording(w http.ResponseWriter, r *http.Request) {
+	recording, err := c.app.GetRecording(mux.Vars(r)["id"])
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	helper.ReturnSuccess(w, recording)
+}
+
+func (c *Controller) UploadRecording(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	c.logger.LogInfo("upload recording request received", "id", id)
+
+	p := struct {
+		IgnoreWindow bool `json:"ignoreWindow"`
+	}{}
+
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		helper.ReturnFailure(w, apperror.InvalidRequest)
+		return
+	}
+
+	recording, deferred, err := c.app.UploadRecordingByID(id, p.IgnoreWindow)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	if deferred != nil {
+		helper.ReturnAccepted(w, deferred)
+		return
+	}
+
+	helper.ReturnSuccess(w, recording)
+}
+
+func (c *Controller) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	c.logger.LogInfo("download file request received", "filename", name)
+
+	file, info, err := c.app.OpenRecording(name)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	defer func() { _ = file.Close() }()
+	serveRecording(w, r, file, info)
+}
+
+func (c *Controller) DownloadRecording(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	c.logger.LogInfo("download recording request received", "id", id)
+
+	file, info, err := c.app.OpenRecordingByID(id, r.URL.Query().Get("kind"))
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	defer func() { _ = file.Close() }()
+	serveRecording(w, r, file, info)
+}
+
+// serveRecording streams a local recording, http.ServeContent takes care of Range,
+// If-Range, If-None-Match and If-Modified-Since requests.
+func serveRecording(w http.ResponseWriter, r *http.Request, file io.ReadSeeker, info os.FileInfo) {
+	contentType := "audio/x-wav"
+	if filepath.Ext(info.Name()) == ".avi" {
+		contentType = "video/x-msvideo"
+	}
+
+	// recordings are never modified once written, so size and time identify the content
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+func (c *Controller) DeleteRecording(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	c.logger.LogInfo("delete recording request received", "id", id)
+
+	recording, err := c.app.DeleteRecording(id)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	helper.ReturnSuccess(w, recording)
+}
+
+func (c *Controller) ListAudioDevices(w http.ResponseWriter, _ *http.Request) {
+	c.logger.LogInfo("list audio devices request received")
+
+	devices, err := c.app.ListAudioDevices()
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	helper.ReturnSuccess(w, devices)
+}
+
+func (c *Controller) SelectAudioDevice(w http.ResponseWriter, r *http.Request) {
+	device := struct {
+		Name string `json:"name"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+		helper.ReturnFailure(w, apperror.InvalidRequest)
+		return
+	}
+
+	if err := c.app.SelectAudioDevice(device.Name); err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	helper.ReturnSuccess(w, nil)
+}
+
+func (c *Controller) StartListening(w http.ResponseWriter, _ *http.Request) {
+	if err := c.app.StartListening(); err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+	c.logger.LogInfo("started voice activated recording")
+	helper.ReturnSuccess(w, nil)
+}
+
+func (c *Controller) StopListening(w http.ResponseWriter, _ *http.Request) {
+	c.app.StopListening()
+	c.logger.LogInfo("stopping voice activated recording")
 	helper.ReturnSuccess(w, nil)
 }
 
@@ -86,7 +390,8 @@ func (c *Controller) UploadFile(w http.ResponseWriter, r *http.Request) {
 	c.logger.LogInfo("upload file request received")
 
 	file := struct {
-		FileName string `json:"fileName"`
+		FileName     string `json:"fileName"`
+		IgnoreWindow bool   `json:"ignoreWindow"`
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&file); err != nil {
@@ -94,18 +399,32 @@ func (c *Controller) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := c.app.UploadRecording(file.FileName); err != nil {
+	deferred, err := c.app.UploadRecording(file.FileName, file.IgnoreWindow)
+
+	if err != nil {
 		helper.ReturnFailure(w, err)
 		return
 	}
 
+	if deferred != nil {
+		helper.ReturnAccepted(w, deferred)
+		return
+	}
+
 	helper.ReturnSuccess(w, nil)
 }
 
-func (c *Controller) ListFiles(w http.ResponseWriter, _ *http.Request) {
+func (c *Controller) ListFiles(w http.ResponseWriter, r *http.Request) {
 	c.logger.LogInfo("list files request received")
 
-	files, err := c.app.FetchRecordings()
+	filter, err := recordingFilter(r)
+
+	if err != nil {
+		helper.ReturnFailure(w, err)
+		return
+	}
+
+	files, err := c.app.FetchRecordings(filter)
 
 	if err != nil {
 		helper.ReturnFailure(w, err)
@@ -115,15 +434,35 @@ func (c *Controller) ListFiles(w http.ResponseWriter, _ *http.Request) {
 	helper.ReturnSuccess(w, files)
 }
 
+func (c *Controller) UploadQueue(w http.ResponseWriter, _ *http.Request) {
+	c.logger.LogInfo("upload queue request received")
+	helper.ReturnSuccess(w, c.app.UploadQueue())
+}
+
+func (c *Controller) UploadProgress(w http.ResponseWriter, _ *http.Request) {
+	c.logger.LogInfo("upload progress request received")
+	helper.ReturnSuccess(w, c.app.UploadProgress())
+}
+
+func (c *Controller) WebhookDeliveries(w http.ResponseWriter, _ *http.Request) {
+	c.logger.LogInfo("webhook deliveries request received")
+	helper.ReturnSuccess(w, c.app.WebhookDeliveries())
+}
+
 func (c *Controller) UploadAllFiles(w http.ResponseWriter, _ *http.Request) {
 	c.logger.LogInfo("upload all files request received")
-	err := c.app.UploadRecordings()
+	deferred, err := c.app.UploadRecordings()
 
 	if err != nil {
 		helper.ReturnFailure(w, err)
 		return
 	}
 
+	if deferred != nil {
+		helper.ReturnAccepted(w, deferred)
+		return
+	}
+
 	helper.ReturnSuccess(w, nil)
 }
 
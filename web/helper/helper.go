@@ -20,6 +20,14 @@ func ReturnFailure(w http.ResponseWriter, err error) {
 	}
 }
 
+// ReturnAccepted tells the client its request was queued to be carried out later.
+func ReturnAccepted(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("status", strconv.Itoa(http.StatusAccepted))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(data)
+}
+
 func ReturnSuccess(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("status", strconv.Itoa(http.StatusOK))
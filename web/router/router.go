@@ -18,11 +18,30 @@ func InitRouter(controller *controller.Controller, logger *logger.Logger) *mux.R
 	filerouter.HandleFunc("/upload", controller.UploadFile).Methods(http.MethodPost)
 	filerouter.HandleFunc("/upload-list", controller.ListFiles).Methods(http.MethodGet)
 	filerouter.HandleFunc("/upload-all", controller.UploadAllFiles).Methods(http.MethodPost)
+	filerouter.HandleFunc("/queue", controller.UploadQueue).Methods(http.MethodGet)
+	filerouter.HandleFunc("/progress", controller.UploadProgress).Methods(http.MethodGet)
+	filerouter.HandleFunc("/webhooks", controller.WebhookDeliveries).Methods(http.MethodGet)
+	filerouter.HandleFunc(`/{name:[^/]+\.(?:avi|wav)}`, controller.DownloadFile).Methods(http.MethodGet, http.MethodHead)
+
+	recordingrouter := router.PathPrefix("/recordings").Subrouter()
+	recordingrouter.HandleFunc("", controller.ListRecordings).Methods(http.MethodGet)
+	recordingrouter.HandleFunc("/{id}", controller.GetRecording).Methods(http.MethodGet)
+	recordingrouter.HandleFunc("/{id}", controller.DeleteRecording).Methods(http.MethodDelete)
+	recordingrouter.HandleFunc("/{id}/upload", controller.UploadRecording).Methods(http.MethodPost)
+	recordingrouter.HandleFunc("/{id}/download", controller.DownloadRecording).Methods(http.MethodGet, http.MethodHead)
 
 	camerarouter := router.PathPrefix("/camera").Subrouter()
 	camerarouter.HandleFunc("/start-recording", controller.StartRecording).Methods(http.MethodPost)
 	camerarouter.HandleFunc("/stop-recording", controller.StopRecording).Methods(http.MethodPost)
 	camerarouter.HandleFunc("/stream.mjpeg", controller.ShowStream).Methods(http.MethodGet)
 
+	audiorouter := router.PathPrefix("/audio").Subrouter()
+	audiorouter.HandleFunc("/devices", controller.ListAudioDevices).Methods(http.MethodGet)
+	audiorouter.HandleFunc("/devices", controller.SelectAudioDevice).Methods(http.MethodPut)
+	audiorouter.HandleFunc("/stream", controller.ListenStream).Methods(http.MethodGet)
+	audiorouter.HandleFunc("/levels", controller.ShowAudioLevels).Methods(http.MethodGet)
+	audiorouter.HandleFunc("/start-listening", controller.StartListening).Methods(http.MethodPost)
+	audiorouter.HandleFunc("/stop-listening", controller.StopListening).Methods(http.MethodPost)
+
 	return router
 }